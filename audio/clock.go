@@ -0,0 +1,108 @@
+package audio
+
+import "time"
+
+// ClocksPerQuarter is the MIDI timing clock resolution (24 PPQN)
+const ClocksPerQuarter = 24
+
+// clocksPerSixteenth is the unit of MIDI Song Position Pointer
+const clocksPerSixteenth = ClocksPerQuarter / 4
+
+// ClockEventKind identifies a MIDI transport message emitted by the engine
+type ClockEventKind int
+
+const (
+	ClockTick ClockEventKind = iota
+	ClockStart
+	ClockStop
+	ClockContinue
+	ClockSongPosition
+)
+
+// ClockEvent is a MIDI clock or transport message produced by the step
+// clock. Frame is the audio frame it belongs to, so a sender can space the
+// events out in time instead of sending each rendered buffer's worth at once.
+type ClockEvent struct {
+	Kind     ClockEventKind
+	Frame    int64
+	Position int // Song position in 16ths, for ClockSongPosition
+}
+
+// FrameDuration converts a number of audio frames to wall time
+func FrameDuration(frames int64) time.Duration {
+	return time.Duration(frames) * time.Second / sampleRate
+}
+
+// SetClockOutput turns MIDI clock generation on or off. Once on, the clock
+// starts at the next 16th so external gear locks to the current position;
+// turning it off sends Stop.
+func (e *Engine) SetClockOutput(enabled bool) {
+	e.update(func(p *mixParams) {
+		p.clockOut = enabled
+	})
+}
+
+// ClockOutput reports whether MIDI clock generation is on
+func (e *Engine) ClockOutput() bool {
+	return e.params.Load().clockOut
+}
+
+// ClockEvents returns the channel the render loop publishes clock events on
+func (e *Engine) ClockEvents() <-chan ClockEvent {
+	return e.clockEvents
+}
+
+// advanceClock runs the 24 PPQN clock for one frame. Ticks are counted from
+// the start of the pattern and realigned there, so the clock cannot drift
+// from the step clock it is derived from. It is called by the render loop
+// only.
+func (e *Engine) advanceClock(frame int64, patternStart bool, ticksPerFrame float64, enabled bool) {
+	ticked := false
+	if patternStart {
+		// A tick that fired a moment ago through rounding becomes tick 0
+		if e.clockPhase >= 0.5 {
+			e.clockPhase = 0
+			ticked = true
+		}
+		e.clockTick = 0
+	} else {
+		e.clockPhase += ticksPerFrame
+		if e.clockPhase >= 1 {
+			e.clockPhase--
+			e.clockTick++
+			ticked = true
+		}
+	}
+
+	if !enabled {
+		if e.clockRunning {
+			e.clockRunning = false
+			e.emitClock(ClockEvent{Kind: ClockStop, Frame: frame})
+		}
+		return
+	}
+	if !ticked {
+		return
+	}
+
+	if !e.clockRunning && e.clockTick%clocksPerSixteenth == 0 {
+		if e.clockTick == 0 {
+			e.emitClock(ClockEvent{Kind: ClockStart, Frame: frame})
+		} else {
+			e.emitClock(ClockEvent{Kind: ClockSongPosition, Frame: frame, Position: e.clockTick / clocksPerSixteenth})
+			e.emitClock(ClockEvent{Kind: ClockContinue, Frame: frame})
+		}
+		e.clockRunning = true
+	}
+	if e.clockRunning {
+		e.emitClock(ClockEvent{Kind: ClockTick, Frame: frame})
+	}
+}
+
+// emitClock publishes an event without ever blocking the render loop
+func (e *Engine) emitClock(ev ClockEvent) {
+	select {
+	case e.clockEvents <- ev:
+	default:
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"math"
 	"math/rand"
 	"sync"
-
-	"github.com/hajimehoshi/oto/v2"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -16,85 +16,19 @@ const (
 	MinBPM       = 60
 	MaxBPM       = 200
 	DefaultBPM   = 120
+
+	// outputGain leaves headroom below full scale after soft clipping
+	outputGain = 0.7
+
+	// DefaultSmoothing is the time constant of gain, pan, mute and solo ramps
+	DefaultSmoothing = 10 * time.Millisecond
+
+	// silentGain is the level below which a fading channel is skipped
+	silentGain = 1e-5
 )
 
-// BeatPreset contains patterns for all drums
-type BeatPreset struct {
-	Name        string
-	Description string
-	Kick        []int
-	Snare       []int
-	HiHat       []int
-	Bass        []int
-}
-
-// Sick beat presets for different vibes
-var BeatPresets = []BeatPreset{
-	{
-		Name:        "🔥 Trap Fire",
-		Description: "Hard-hitting trap beat with rolling hi-hats",
-		Kick:        []int{1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0},
-		Snare:       []int{0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0},
-		HiHat:       []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
-		Bass:        []int{1, 0, 0, 1, 0, 0, 1, 0, 1, 0, 0, 1, 0, 0, 0, 1},
-	},
-	{
-		Name:        "🎸 Rock Solid",
-		Description: "Classic rock beat - simple but powerful",
-		Kick:        []int{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0},
-		Snare:       []int{0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0},
-		HiHat:       []int{1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0},
-		Bass:        []int{1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 0, 0, 0},
-	},
-	{
-		Name:        "🕺 Disco Funk",
-		Description: "Groovy disco vibes with syncopated rhythm",
-		Kick:        []int{1, 0, 0, 1, 0, 0, 1, 0, 0, 0, 1, 0, 1, 0, 0, 0},
-		Snare:       []int{0, 0, 0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0},
-		HiHat:       []int{0, 1, 1, 1, 0, 1, 1, 1, 0, 1, 1, 1, 0, 1, 1, 1},
-		Bass:        []int{1, 0, 1, 0, 0, 1, 0, 1, 1, 0, 1, 0, 0, 1, 0, 1},
-	},
-	{
-		Name:        "🌊 Lo-Fi Chill",
-		Description: "Relaxed, laid-back beats to study to",
-		Kick:        []int{1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0},
-		Snare:       []int{0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 1, 0, 0, 1, 0},
-		HiHat:       []int{1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1},
-		Bass:        []int{1, 0, 0, 0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0},
-	},
-	{
-		Name:        "🎹 House Party",
-		Description: "Four-on-the-floor house music energy",
-		Kick:        []int{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0},
-		Snare:       []int{0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0},
-		HiHat:       []int{0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0},
-		Bass:        []int{1, 0, 0, 1, 0, 0, 0, 1, 1, 0, 0, 1, 0, 0, 0, 1},
-	},
-	{
-		Name:        "💀 Dubstep Drop",
-		Description: "Heavy wobbles and aggressive rhythms",
-		Kick:        []int{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 1, 0, 0, 0, 0},
-		Snare:       []int{0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 0},
-		HiHat:       []int{1, 1, 0, 1, 1, 1, 0, 1, 1, 1, 0, 1, 1, 1, 0, 1},
-		Bass:        []int{1, 0, 1, 0, 0, 0, 1, 1, 0, 0, 1, 0, 0, 1, 1, 0},
-	},
-	{
-		Name:        "🥁 Drum & Bass",
-		Description: "Fast-paced jungle rhythms",
-		Kick:        []int{1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0},
-		Snare:       []int{0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0},
-		HiHat:       []int{1, 0, 1, 1, 0, 1, 1, 0, 1, 1, 0, 1, 1, 0, 1, 1},
-		Bass:        []int{1, 0, 0, 1, 0, 1, 0, 0, 1, 0, 0, 1, 0, 1, 0, 0},
-	},
-	{
-		Name:        "🎺 Latin Heat",
-		Description: "Salsa-inspired rhythm with clave pattern",
-		Kick:        []int{1, 0, 0, 1, 0, 0, 1, 0, 0, 0, 1, 0, 1, 0, 0, 0},
-		Snare:       []int{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0},
-		HiHat:       []int{1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0},
-		Bass:        []int{1, 0, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0},
-	},
-}
+// rowChannels maps pattern rows to the channels they trigger
+var rowChannels = [NumRows]int{ChKick, ChSnare, ChHiHat, ChBass}
 
 // Channel types
 const (
@@ -111,26 +45,79 @@ const (
 // Channel names for display
 var ChannelNames = []string{"KICK", "SNARE", "HIHAT", "BASS", "LEAD1", "LEAD2", "PAD", "FX"}
 
+// Engine synthesizes the mix. Control changes from the UI and MIDI are
+// published as immutable mixParams snapshots that the audio thread picks up
+// at buffer boundaries, so the render loop never waits on a lock held by a
+// caller. Everything below the params field is owned by the render loop.
 type Engine struct {
-	ctx          *oto.Context
-	player       oto.Player
-	mu           sync.RWMutex
+	sink        Sink
+	mu          sync.Mutex // Serializes writers of params; never taken by the render loop
+	params      atomic.Pointer[mixParams]
+	running     atomic.Bool
+	currentStep atomic.Int64
+
+	waveformL   []float64
+	waveformR   []float64
+	waveformIdx int
+	waveformMu  sync.RWMutex
+
+	samplePos  int64
+	step       int   // Sequencer step being played
+	stepPos    int64 // Samples elapsed within the step
+	restarts   int   // Restart requests handled, see mixParams.restarts
+	runGain    float64
+	envelopes  []float64
+	ages       []int64   // Samples since each channel was last triggered
+	released   []bool    // Melodic voices whose note has been let go
+	pitches    []float64 // Pitch set by the last note played, 0 if none
+	heldNotes  []int     // Note sounding on each melodic channel, -1 if none
+	gated      []bool    // Drones played from MIDI, which follow their envelope
+	noteEvents chan noteEvent
+	noisePhase float64
+	bassPhase  float64
+	leadPhases []float64
+	padPhases  [4]float64
+	rng        *rand.Rand
+
+	// MIDI clock derived from the step clock
+	clockEvents  chan ClockEvent
+	clockPhase   float64 // Fraction of a tick elapsed since the last one
+	clockTick    int     // Ticks since the start of the pattern
+	clockRunning bool    // Whether Start/Continue has been sent
+
+	// Incoming MIDI clock from a master, applied at buffer boundaries
+	syncEvents chan ClockEvent
+	extRunning bool    // Master transport is running
+	extArmed   bool    // Start or Continue received, waiting for the first tick
+	extTicks   int64   // Song position in ticks received from the master
+	ownTicks   float64 // Song position in ticks played by the step clock
+	extPlaying atomic.Bool
+
+	// Smoothed per-channel left/right gains and master level, ramped toward
+	// the targets implied by params to avoid zipper noise and clicks
+	gainL       []float64
+	gainR       []float64
+	targetL     []float64
+	targetR     []float64
+	masterGain  float64
+	gainsPrimed bool
+}
+
+// mixParams is a snapshot of every control the render loop reads. Snapshots
+// are never modified after being published; writers copy, change and swap.
+type mixParams struct {
 	channels     []ChannelState
 	master       float64
-	running      bool
-	samplePos    int64
-	waveformL    []float64
-	waveformR    []float64
-	waveformIdx  int
-	waveformMu   sync.RWMutex
-	envelopes    []float64
-	noisePhase   float64
-	bassPhase    float64
-	leadPhases   []float64
-	padPhases    []float64
-	BPM          int
-	PatternIndex int
-	CurrentStep  int
+	bpm          int
+	patterns     []BeatPreset
+	patternIndex int
+	smoothing    float64 // Per-sample ramp coefficient, 1 = instant
+	transport    Transport
+	restarts     int // Bumped to rewind to step 0
+	clockOut     bool
+	follow       bool    // Step clock follows incoming MIDI clock
+	extTempo     float64 // Tempo measured from the clock master, 0 if unknown
+	swing        int     // Off-beat 16th delay, 0 (straight) to MaxSwing
 }
 
 type ChannelState struct {
@@ -143,17 +130,30 @@ type ChannelState struct {
 
 type audioStream struct {
 	engine *Engine
+	left   []float64
+	right  []float64
 }
 
-func NewEngine(numChannels int) (*Engine, error) {
-	ctx, ready, err := oto.NewContext(sampleRate, channelCount, bitDepth)
-	if err != nil {
+// NewEngine creates an engine that plays through the given output sink
+func NewEngine(numChannels int, sink Sink) (*Engine, error) {
+	e := newEngine(numChannels)
+	if err := sink.Start(&audioStream{engine: e}); err != nil {
 		return nil, err
 	}
-	<-ready
+	e.sink = sink
 
+	return e, nil
+}
+
+// NewOfflineEngine creates an engine with no audio output. It is used for
+// rendering to files on machines without a sound card.
+func NewOfflineEngine(numChannels int) *Engine {
+	return newEngine(numChannels)
+}
+
+// newEngine initializes the synthesis state shared by live and offline engines
+func newEngine(numChannels int) *Engine {
 	channels := make([]ChannelState, numChannels)
-	envelopes := make([]float64, numChannels)
 
 	// Initialize channel defaults
 	defaults := []struct {
@@ -186,43 +186,103 @@ func NewEngine(numChannels int) (*Engine, error) {
 	}
 
 	e := &Engine{
-		ctx:          ctx,
+		waveformL:  make([]float64, waveformSize),
+		waveformR:  make([]float64, waveformSize),
+		envelopes:  make([]float64, numChannels),
+		ages:       make([]int64, numChannels),
+		released:   make([]bool, numChannels),
+		pitches:    make([]float64, numChannels),
+		heldNotes:  make([]int, numChannels),
+		gated:      make([]bool, numChannels),
+		noteEvents: make(chan noteEvent, 256),
+		leadPhases: make([]float64, 2),
+		rng:        rand.New(rand.NewSource(1)),
+		gainL:      make([]float64, numChannels),
+		gainR:      make([]float64, numChannels),
+		targetL:    make([]float64, numChannels),
+		targetR:    make([]float64, numChannels),
+
+		clockEvents: make(chan ClockEvent, 512),
+		clockPhase:  1,
+		syncEvents:  make(chan ClockEvent, 512),
+		runGain:     1,
+	}
+	for i := range e.heldNotes {
+		e.heldNotes[i] = -1
+	}
+	e.params.Store(&mixParams{
 		channels:     channels,
 		master:       0.8,
-		running:      true,
-		waveformL:    make([]float64, waveformSize),
-		waveformR:    make([]float64, waveformSize),
-		envelopes:    envelopes,
-		leadPhases:   make([]float64, 2),
-		padPhases:    make([]float64, 4),
-		BPM:          DefaultBPM,
-		PatternIndex: 0,
-		CurrentStep:  0,
-	}
-
-	e.player = ctx.NewPlayer(&audioStream{engine: e})
-	e.player.Play()
-
-	return e, nil
+		bpm:          DefaultBPM,
+		patterns:     DefaultPatterns(),
+		patternIndex: 0,
+		smoothing:    smoothingCoef(DefaultSmoothing),
+		transport:    TransportPlaying,
+	})
+	e.running.Store(true)
+
+	return e
 }
 
 func (s *audioStream) Read(buf []byte) (int, error) {
-	s.engine.mu.RLock()
-	running := s.engine.running
-	master := s.engine.master
-	channels := make([]ChannelState, len(s.engine.channels))
-	copy(channels, s.engine.channels)
-	envelopes := make([]float64, len(s.engine.envelopes))
-	copy(envelopes, s.engine.envelopes)
-	s.engine.mu.RUnlock()
-
-	if !running {
+	frames := len(buf) / 4
+	if cap(s.left) < frames {
+		s.left = make([]float64, frames)
+		s.right = make([]float64, frames)
+	}
+	left, right := s.left[:frames], s.right[:frames]
+
+	if !s.engine.mix(left, right) {
 		for i := range buf {
 			buf[i] = 0
 		}
 		return len(buf), nil
 	}
 
+	for i := 0; i < frames; i++ {
+		leftInt := int16(left[i] * 32767 * outputGain)
+		rightInt := int16(right[i] * 32767 * outputGain)
+
+		idx := i * 4
+		buf[idx] = byte(leftInt)
+		buf[idx+1] = byte(leftInt >> 8)
+		buf[idx+2] = byte(rightInt)
+		buf[idx+3] = byte(rightInt >> 8)
+	}
+
+	return len(buf), nil
+}
+
+// smoothingCoef converts a ramp time constant into a one-pole coefficient
+func smoothingCoef(d time.Duration) float64 {
+	if d <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-1/(d.Seconds()*sampleRate))
+}
+
+// samplesPerStep returns the length of one step at the given tempo, with
+// stepsPerBeat steps to a quarter note. Steps are at least one sample long.
+func samplesPerStep(bpm float64, stepsPerBeat int) int {
+	return max(int(sampleRate*60/bpm)/stepsPerBeat, 1)
+}
+
+// mix advances the sequencer by len(left) frames and writes the mixed,
+// soft-clipped output into left and right. It returns false without
+// touching the buffers when the engine has been closed.
+//
+// mix must only be called from one goroutine at a time. It reads a single
+// params snapshot for the whole buffer and takes no locks except the brief
+// waveform copy at the end.
+func (e *Engine) mix(left, right []float64) bool {
+	if !e.running.Load() {
+		return false
+	}
+
+	p := e.params.Load()
+	channels := p.channels
+	coef := p.smoothing
+
 	anySolo := false
 	for _, ch := range channels {
 		if ch.Solo {
@@ -231,84 +291,157 @@ func (s *audioStream) Read(buf []byte) (int, error) {
 		}
 	}
 
-	// Get current BPM and pattern
-	s.engine.mu.RLock()
-	bpm := s.engine.BPM
-	patternIdx := s.engine.PatternIndex
-	s.engine.mu.RUnlock()
+	// Work out where each channel's gains should end up. Mute and solo are
+	// just a target of zero, so they ramp like any other gain change.
+	targetL, targetR := e.targetL, e.targetR
+	for chIdx := range channels {
+		ch := &channels[chIdx]
+		targetL[chIdx], targetR[chIdx] = 0, 0
+		if ch.Mute || (anySolo && !ch.Solo) {
+			continue
+		}
+		angle := (ch.Pan + 1) * math.Pi / 4
+		targetL[chIdx] = ch.Volume * math.Cos(angle)
+		targetR[chIdx] = ch.Volume * math.Sin(angle)
+	}
+	if !e.gainsPrimed {
+		copy(e.gainL, targetL)
+		copy(e.gainR, targetR)
+		e.masterGain = p.master
+		e.gainsPrimed = true
+	}
 
-	if patternIdx >= len(BeatPresets) {
+	patternIdx := p.patternIndex
+	if patternIdx >= len(p.patterns) {
 		patternIdx = 0
 	}
-	pattern := BeatPresets[patternIdx]
+	pattern := &p.patterns[patternIdx]
 
-	samplesPerBeat := sampleRate * 60 / bpm / 4 // 16th notes
+	tempo := float64(p.bpm)
+	if p.follow && p.extTempo > 0 {
+		tempo = p.extTempo
+	}
+	stepLen := int64(samplesPerStep(tempo, pattern.StepsPerBeat()))
+	steps := pattern.Steps()
+
+	// The step clock runs while the transport plays. While following an
+	// external clock, the master's transport decides instead.
+	moving := p.transport == TransportPlaying
+	if p.follow {
+		stepLen = max(e.followClock(pattern, stepLen), 1)
+		moving = e.extRunning
+	} else if p.restarts != e.restarts {
+		e.restarts = p.restarts
+		e.locate(pattern, stepLen, 0)
+	} else if p.transport == TransportStopped && (e.step != 0 || e.stepPos != 0) {
+		e.locate(pattern, stepLen, 0)
+	}
 
-	samples := len(buf) / 4
-	for i := 0; i < samples; i++ {
-		s.engine.mu.Lock()
-		samplePos := s.engine.samplePos
-		s.engine.samplePos++
+	e.applyNotes()
 
-		step := int(samplePos/int64(samplesPerBeat)) % 16
-		s.engine.CurrentStep = step
-		stepProgress := float64(samplePos%int64(samplesPerBeat)) / float64(samplesPerBeat)
+	// Stopping fades everything out, drones included, and playing fades back in
+	runTarget, runCoef := 0.0, smoothingCoef(transportFade)
+	if moving {
+		runTarget = 1
+	}
 
-		// Trigger envelopes on beat
-		if samplePos%int64(samplesPerBeat) == 0 {
-			if pattern.Kick[step] == 1 {
-				s.engine.envelopes[ChKick] = 1.0
-			}
-			if pattern.Snare[step] == 1 {
-				s.engine.envelopes[ChSnare] = 1.0
+	// Swing delays the off-beat 16ths by up to half a 16th. Every other step
+	// stays on the straight grid, so beats, and patterns of any length,
+	// keep their timing. At 32nds the delay stops short of the next step.
+	spb := pattern.StepsPerBeat()
+	swingDelay := min(stepLen*int64(spb)/4*int64(p.swing)/(2*MaxSwing), stepLen-1)
+
+	// MIDI clock stays straight when the pattern swings
+	ticksPerFrame := float64(ClocksPerQuarter) / float64(stepLen*int64(pattern.StepsPerBeat()))
+
+	for i := range left {
+		samplePos := e.samplePos
+		e.samplePos++
+
+		step := e.step
+		if !moving {
+			e.advanceClock(samplePos, false, 0, false)
+		} else {
+			// Advance the step clock. Counting within the step rather than
+			// dividing the sample position keeps the playhead in place across
+			// tempo and pattern changes.
+			next := (e.step + 1) % steps
+			curLen := stepLen + swingOffset(next, spb, swingDelay) - swingOffset(e.step, spb, swingDelay)
+			if e.stepPos >= curLen {
+				e.stepPos = 0
+				e.step++
 			}
-			if pattern.HiHat[step] == 1 {
-				s.engine.envelopes[ChHiHat] = 1.0
+			if e.step >= steps {
+				e.step = 0
 			}
-			if pattern.Bass[step] == 1 {
-				s.engine.envelopes[ChBass] = 1.0
+			step = e.step
+
+			e.advanceClock(samplePos, step == 0 && e.stepPos == 0, ticksPerFrame, p.clockOut)
+
+			// Trigger envelopes on beat
+			if e.stepPos == 0 {
+				accent := 1.0
+				if pattern.Accented(step) {
+					accent = AccentBoost
+				}
+				for row, ch := range rowChannels {
+					if vel := pattern.Velocity(row, step); vel > 0 && ch < len(e.envelopes) {
+						e.trigger(ch, float64(vel)/DefaultVelocity*accent)
+					}
+				}
 			}
+
+			e.stepPos++
+			e.ownTicks += ticksPerFrame
 		}
 
-		// Decay envelopes
-		for j := range s.engine.envelopes {
-			s.engine.envelopes[j] *= 0.9997
+		// Decay envelopes. Gated drones sustain while their note is held.
+		for j := range e.envelopes {
+			if e.released[j] {
+				e.envelopes[j] *= noteRelease
+			} else if !e.gated[j] {
+				e.envelopes[j] *= 0.9997
+			}
+			e.ages[j]++
 		}
 
 		var leftSum, rightSum float64
 
 		// Generate each channel
 		for chIdx := range channels {
-			ch := channels[chIdx]
-			if ch.Mute || (anySolo && !ch.Solo) {
+			ch := &channels[chIdx]
+			e.gainL[chIdx] += (targetL[chIdx] - e.gainL[chIdx]) * coef
+			e.gainR[chIdx] += (targetR[chIdx] - e.gainR[chIdx]) * coef
+			if targetL[chIdx] == 0 && targetR[chIdx] == 0 && e.gainL[chIdx] < silentGain && e.gainR[chIdx] < silentGain {
 				continue
 			}
 
 			var sample float64
-			env := s.engine.envelopes[chIdx]
+			env := e.envelopes[chIdx]
+			progress := float64(e.ages[chIdx]) / float64(stepLen)
 
 			switch chIdx {
 			case ChKick:
 				// Kick: pitch-dropping sine
-				kickFreq := 150*math.Exp(-5*stepProgress) + 40
-				s.engine.bassPhase += 2 * math.Pi * kickFreq / sampleRate
-				sample = math.Sin(s.engine.bassPhase) * env * 1.2
+				kickFreq := 150*math.Exp(-5*progress) + 40
+				e.bassPhase += 2 * math.Pi * kickFreq / sampleRate
+				sample = math.Sin(e.bassPhase) * env * 1.2
 
 			case ChSnare:
 				// Snare: noise + tone
-				s.engine.noisePhase += 0.1
-				noise := (rand.Float64()*2 - 1) * 0.6
-				tone := math.Sin(s.engine.noisePhase*200) * 0.4
+				e.noisePhase += 0.1
+				noise := (e.rng.Float64()*2 - 1) * 0.6
+				tone := math.Sin(e.noisePhase*200) * 0.4
 				sample = (noise + tone) * env
 
 			case ChHiHat:
 				// HiHat: filtered noise
-				noise := rand.Float64()*2 - 1
-				sample = noise * env * 0.5 * math.Exp(-10*stepProgress)
+				noise := e.rng.Float64()*2 - 1
+				sample = noise * env * 0.5 * math.Exp(-10*progress)
 
 			case ChBass:
 				// Bass: saw-ish wave
-				bassFreq := ch.Frequency
+				bassFreq := e.pitch(chIdx, ch)
 				t := float64(samplePos) / sampleRate
 				saw := 2*math.Mod(t*bassFreq, 1) - 1
 				sample = saw * env * 0.7
@@ -316,17 +449,24 @@ func (s *audioStream) Read(buf []byte) (int, error) {
 			case ChLead1, ChLead2:
 				// Lead: detuned saws
 				idx := chIdx - ChLead1
-				freq := ch.Frequency * (1 + float64(step%4)*0.02)
-				s.engine.leadPhases[idx] += 2 * math.Pi * freq / sampleRate
-				sample = math.Sin(s.engine.leadPhases[idx]) * 0.5
-				sample += math.Sin(s.engine.leadPhases[idx]*2.01) * 0.25
+				freq := e.pitch(chIdx, ch) * (1 + float64(step%4)*0.02)
+				e.leadPhases[idx] += 2 * math.Pi * freq / sampleRate
+				sample = math.Sin(e.leadPhases[idx]) * 0.5
+				sample += math.Sin(e.leadPhases[idx]*2.01) * 0.25
+				if e.gated[chIdx] {
+					sample *= env
+				}
 
 			case ChPad:
 				// Pad: soft chord
-				freqs := []float64{ch.Frequency, ch.Frequency * 1.25, ch.Frequency * 1.5, ch.Frequency * 2}
+				root := e.pitch(chIdx, ch)
+				freqs := [4]float64{root, root * 1.25, root * 1.5, root * 2}
 				for pi, f := range freqs {
-					s.engine.padPhases[pi] += 2 * math.Pi * f / sampleRate
-					sample += math.Sin(s.engine.padPhases[pi]) * 0.15
+					e.padPhases[pi] += 2 * math.Pi * f / sampleRate
+					sample += math.Sin(e.padPhases[pi]) * 0.15
+				}
+				if e.gated[chIdx] {
+					sample *= env
 				}
 
 			case ChFX:
@@ -335,39 +475,38 @@ func (s *audioStream) Read(buf []byte) (int, error) {
 				sample = math.Sin(float64(samplePos)*0.05*(1+sweep*0.5)) * 0.3
 			}
 
-			sample *= ch.Volume
-
-			// Panning
-			angle := (ch.Pan + 1) * math.Pi / 4
-			leftSum += sample * math.Cos(angle)
-			rightSum += sample * math.Sin(angle)
+			leftSum += sample * e.gainL[chIdx]
+			rightSum += sample * e.gainR[chIdx]
 		}
 
-		s.engine.mu.Unlock()
-
-		leftSum *= master
-		rightSum *= master
-		leftSum = softClip(leftSum)
-		rightSum = softClip(rightSum)
+		e.masterGain += (p.master - e.masterGain) * coef
+		e.runGain += (runTarget - e.runGain) * runCoef
+		leftSum *= e.masterGain * e.runGain
+		rightSum *= e.masterGain * e.runGain
+		left[i] = softClip(leftSum)
+		right[i] = softClip(rightSum)
+	}
 
-		// Store waveform for visualization
-		s.engine.waveformMu.Lock()
-		s.engine.waveformL[s.engine.waveformIdx] = leftSum
-		s.engine.waveformR[s.engine.waveformIdx] = rightSum
-		s.engine.waveformIdx = (s.engine.waveformIdx + 1) % waveformSize
-		s.engine.waveformMu.Unlock()
+	e.currentStep.Store(int64(e.step))
+	e.storeWaveform(left, right)
 
-		leftInt := int16(leftSum * 32767 * 0.7)
-		rightInt := int16(rightSum * 32767 * 0.7)
+	return true
+}
 
-		idx := i * 4
-		buf[idx] = byte(leftInt)
-		buf[idx+1] = byte(leftInt >> 8)
-		buf[idx+2] = byte(rightInt)
-		buf[idx+3] = byte(rightInt >> 8)
+// storeWaveform keeps the tail of a rendered buffer for visualization
+func (e *Engine) storeWaveform(left, right []float64) {
+	start := 0
+	if len(left) > waveformSize {
+		start = len(left) - waveformSize
 	}
 
-	return len(buf), nil
+	e.waveformMu.Lock()
+	defer e.waveformMu.Unlock()
+	for i := start; i < len(left); i++ {
+		e.waveformL[e.waveformIdx] = left[i]
+		e.waveformR[e.waveformIdx] = right[i]
+		e.waveformIdx = (e.waveformIdx + 1) % waveformSize
+	}
 }
 
 func softClip(x float64) float64 {
@@ -397,109 +536,252 @@ func (e *Engine) GetWaveform() ([]float64, []float64) {
 	return left, right
 }
 
-func (e *Engine) SetChannelVolume(channel int, value uint8) {
+// update publishes a new params snapshot produced by applying fn to a copy
+// of the current one
+func (e *Engine) update(fn func(p *mixParams)) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	if channel >= 0 && channel < len(e.channels) {
-		e.channels[channel].Volume = float64(value) / 127.0
-	}
+
+	next := *e.params.Load()
+	next.channels = append([]ChannelState(nil), next.channels...)
+	fn(&next)
+	e.params.Store(&next)
 }
 
-func (e *Engine) SetChannelPan(channel int, value uint8) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	if channel >= 0 && channel < len(e.channels) {
-		e.channels[channel].Pan = (float64(value) - 64) / 64.0
-	}
+// updateChannel publishes a change to a single channel
+func (e *Engine) updateChannel(channel int, fn func(ch *ChannelState)) {
+	e.update(func(p *mixParams) {
+		if channel >= 0 && channel < len(p.channels) {
+			fn(&p.channels[channel])
+		}
+	})
+}
+
+// Volumes and pans are set as 14-bit levels from 0 to MaxLevel, with pan
+// centered at LevelCenter
+const (
+	MaxLevel    = 16383
+	LevelCenter = 8192
+)
+
+func (e *Engine) SetChannelVolume(channel int, value uint16) {
+	e.updateChannel(channel, func(ch *ChannelState) {
+		ch.Volume = float64(value) / MaxLevel
+	})
+}
+
+func (e *Engine) SetChannelPan(channel int, value uint16) {
+	e.updateChannel(channel, func(ch *ChannelState) {
+		ch.Pan = (float64(value) - LevelCenter) / LevelCenter
+	})
 }
 
 func (e *Engine) SetChannelMute(channel int, muted bool) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	if channel >= 0 && channel < len(e.channels) {
-		e.channels[channel].Mute = muted
-	}
+	e.updateChannel(channel, func(ch *ChannelState) {
+		ch.Mute = muted
+	})
 }
 
 func (e *Engine) SetChannelSolo(channel int, solo bool) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	if channel >= 0 && channel < len(e.channels) {
-		e.channels[channel].Solo = solo
+	e.updateChannel(channel, func(ch *ChannelState) {
+		ch.Solo = solo
+	})
+}
+
+func (e *Engine) SetMasterVolume(value uint16) {
+	e.update(func(p *mixParams) {
+		p.master = float64(value) / MaxLevel
+	})
+}
+
+// MaxSwing delays off-beat 16ths by half a 16th, a dotted feel. A swing of
+// about 67 gives a triplet shuffle.
+const MaxSwing = 100
+
+// SetSwing sets the swing of every pattern, from 0 (straight) to MaxSwing
+func (e *Engine) SetSwing(swing int) {
+	if swing < 0 {
+		swing = 0
+	}
+	if swing > MaxSwing {
+		swing = MaxSwing
 	}
+	e.update(func(p *mixParams) {
+		p.swing = swing
+	})
 }
 
-func (e *Engine) SetMasterVolume(value uint8) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	e.master = float64(value) / 127.0
+// GetSwing returns the swing amount
+func (e *Engine) GetSwing() int {
+	return e.params.Load().swing
+}
+
+// swingOffset returns how late swing starts a step: by delay for a step on
+// an off-beat 16th, the second and fourth 16th of a beat, and not at all for
+// any other. Resolutions without 16ths, such as triplets, stay straight.
+func swingOffset(step, stepsPerBeat int, delay int64) int64 {
+	sixteenths := step % stepsPerBeat * 4
+	if sixteenths%stepsPerBeat == 0 && sixteenths/stepsPerBeat%2 == 1 {
+		return delay
+	}
+	return 0
+}
+
+// SetSmoothing sets the time constant used to ramp gain, pan, mute and solo
+// changes. Zero applies changes instantly.
+func (e *Engine) SetSmoothing(d time.Duration) {
+	e.update(func(p *mixParams) {
+		p.smoothing = smoothingCoef(d)
+	})
 }
 
 // SetBPM sets the tempo in beats per minute
 func (e *Engine) SetBPM(bpm int) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
 	if bpm < MinBPM {
 		bpm = MinBPM
 	}
 	if bpm > MaxBPM {
 		bpm = MaxBPM
 	}
-	e.BPM = bpm
+	e.update(func(p *mixParams) {
+		p.bpm = bpm
+	})
 }
 
-// GetBPM returns current tempo
+// GetBPM returns current tempo, as measured from the clock master while
+// following an external clock
 func (e *Engine) GetBPM() int {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	return e.BPM
+	p := e.params.Load()
+	if p.follow && p.extTempo > 0 {
+		return int(math.Round(p.extTempo))
+	}
+	return p.bpm
 }
 
 // SetPattern sets the current beat pattern
 func (e *Engine) SetPattern(index int) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	if index >= 0 && index < len(BeatPresets) {
-		e.PatternIndex = index
-	}
+	e.update(func(p *mixParams) {
+		if index >= 0 && index < len(p.patterns) {
+			p.patternIndex = index
+		}
+	})
 }
 
 // GetPattern returns current pattern index
 func (e *Engine) GetPattern() int {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	return e.PatternIndex
+	return e.params.Load().patternIndex
 }
 
-// GetCurrentStep returns the current step (0-15)
+// GetCurrentStep returns the playhead position within the current pattern
 func (e *Engine) GetCurrentStep() int {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	return e.CurrentStep
+	return int(e.currentStep.Load())
 }
 
 // NextPattern cycles to the next pattern
 func (e *Engine) NextPattern() {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	e.PatternIndex = (e.PatternIndex + 1) % len(BeatPresets)
+	e.update(func(p *mixParams) {
+		p.patternIndex = (p.patternIndex + 1) % len(p.patterns)
+	})
 }
 
 // PrevPattern cycles to the previous pattern
 func (e *Engine) PrevPattern() {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	e.PatternIndex--
-	if e.PatternIndex < 0 {
-		e.PatternIndex = len(BeatPresets) - 1
+	e.update(func(p *mixParams) {
+		p.patternIndex--
+		if p.patternIndex < 0 {
+			p.patternIndex = len(p.patterns) - 1
+		}
+	})
+}
+
+// SetPatterns replaces the pattern library, keeping the current selection
+// when it still exists
+func (e *Engine) SetPatterns(patterns []BeatPreset) {
+	if len(patterns) == 0 {
+		return
+	}
+	e.update(func(p *mixParams) {
+		p.patterns = append([]BeatPreset(nil), patterns...)
+		if p.patternIndex >= len(p.patterns) {
+			p.patternIndex = 0
+		}
+	})
+}
+
+// PatternCount returns the number of patterns in the library
+func (e *Engine) PatternCount() int {
+	return len(e.params.Load().patterns)
+}
+
+// CurrentPattern returns the pattern that is playing, including any edits
+func (e *Engine) CurrentPattern() BeatPreset {
+	p := e.params.Load()
+	if p.patternIndex >= len(p.patterns) {
+		return p.patterns[0]
 	}
+	return p.patterns[p.patternIndex]
+}
+
+// SetStep sets the velocity of a hit in the current pattern, 0 for a rest.
+// The edit is picked up by the render loop on its next buffer, so it is
+// heard the next time the playhead reaches the step.
+func (e *Engine) SetStep(row, step, velocity int) {
+	if velocity < 0 || velocity > MaxVelocity {
+		return
+	}
+	e.editPattern(func(pattern *BeatPreset) {
+		hits := append([]int(nil), pattern.Row(row)...)
+		if step >= 0 && step < len(hits) {
+			hits[step] = velocity
+			pattern.setRow(row, hits)
+		}
+	})
+}
+
+// ToggleAccent flips the accent on a step of the current pattern
+func (e *Engine) ToggleAccent(step int) {
+	e.editPattern(func(pattern *BeatPreset) {
+		if step < 0 || step >= pattern.Steps() {
+			return
+		}
+		accent := make([]int, pattern.Steps())
+		copy(accent, pattern.Accent)
+		if accent[step] != 0 {
+			accent[step] = 0
+		} else {
+			accent[step] = 1
+		}
+		pattern.Accent = accent
+	})
+}
+
+// editPattern applies fn to a copy of the current pattern and publishes it.
+// fn must copy any row it changes: the previous snapshot may still be
+// rendering.
+func (e *Engine) editPattern(fn func(pattern *BeatPreset)) {
+	e.update(func(p *mixParams) {
+		if p.patternIndex >= len(p.patterns) {
+			return
+		}
+		pattern := p.patterns[p.patternIndex]
+		fn(&pattern)
+
+		p.patterns = append([]BeatPreset(nil), p.patterns...)
+		p.patterns[p.patternIndex] = pattern
+	})
 }
 
 func (e *Engine) Close() {
-	e.mu.Lock()
-	e.running = false
-	e.mu.Unlock()
-	if e.player != nil {
-		e.player.Close()
+	e.running.Store(false)
+	if e.sink != nil {
+		e.sink.Close()
+	}
+}
+
+// OutputName describes the sink the engine plays through
+func (e *Engine) OutputName() string {
+	if e.sink == nil {
+		return "offline"
 	}
+	return e.sink.String()
 }
@@ -0,0 +1,93 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+// stepStarts plays a pattern of the given length and resolution at 120 BPM
+// with the given swing and returns the frame each step starts on, for two
+// loops of the pattern
+func stepStarts(t *testing.T, steps, resolution, swing int) []int64 {
+	t.Helper()
+	e := newEngine(8)
+	e.SetPatterns([]BeatPreset{{Name: "test", Resolution: resolution, Kick: make([]int, steps)}})
+	e.SetSwing(swing)
+
+	left, right := make([]float64, 1), make([]float64, 1)
+	var starts []int64
+	for frame := int64(0); len(starts) <= 2*steps; frame++ {
+		e.mix(left, right)
+		if e.stepPos == 1 {
+			starts = append(starts, frame)
+		}
+	}
+	return starts
+}
+
+func TestSwingKeepsBeatsOnGrid(t *testing.T) {
+	tests := []struct {
+		name              string
+		steps, resolution int
+	}{
+		{"16ths", 16, 4},
+		{"odd length", 7, 4},
+		{"8th triplets", 12, 3},
+		{"16th triplets", 12, 6},
+		{"32nds", 16, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stepLen := int64(samplesPerStep(DefaultBPM, tt.resolution))
+			straight := stepStarts(t, tt.steps, tt.resolution, 0)
+			swung := stepStarts(t, tt.steps, tt.resolution, MaxSwing)
+
+			if loop := swung[tt.steps] - swung[0]; loop != int64(tt.steps)*stepLen {
+				t.Errorf("pattern loops in %d frames, want %d", loop, int64(tt.steps)*stepLen)
+			}
+			for i := range swung {
+				if i%tt.steps%tt.resolution == 0 && swung[i] != straight[i] {
+					t.Errorf("beat at step %d starts on frame %d, want %d", i, swung[i], straight[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSwingDelaysOffBeatSixteenths(t *testing.T) {
+	stepLen := int64(samplesPerStep(DefaultBPM, 4))
+	straight := stepStarts(t, 16, 4, 0)
+	swung := stepStarts(t, 16, 4, MaxSwing)
+	for i := range 16 {
+		want := straight[i]
+		if i%2 == 1 {
+			want += stepLen / 2
+		}
+		if swung[i] != want {
+			t.Errorf("step %d starts on frame %d, want %d", i, swung[i], want)
+		}
+	}
+
+	// Triplets have no off-beat 16ths to swing
+	straight = stepStarts(t, 12, 3, 0)
+	swung = stepStarts(t, 12, 3, MaxSwing)
+	for i := range swung {
+		if swung[i] != straight[i] {
+			t.Errorf("triplet step %d starts on frame %d, want %d", i, swung[i], straight[i])
+		}
+	}
+}
+
+// BenchmarkMix measures mixing one buffer of the size paced sinks pull, with
+// all 8 channels audible. A buffer must mix well within the 10ms it plays for.
+func BenchmarkMix(b *testing.B) {
+	e := newEngine(8)
+	frames := int(sampleRate * pacedChunk / time.Second)
+	left := make([]float64, frames)
+	right := make([]float64, frames)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		e.mix(left, right)
+	}
+}
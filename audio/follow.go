@@ -0,0 +1,121 @@
+package audio
+
+import "math"
+
+// Limits of external clock following
+const (
+	// correctionPerTick is the tempo nudge applied per tick of drift, so the
+	// playhead eases back into phase instead of jumping
+	correctionPerTick = 0.005
+	maxCorrection     = 0.02
+
+	// relocateTicks is the drift at which the playhead jumps to the master's
+	// position, e.g. after the audio device stalled
+	relocateTicks = 2 * clocksPerSixteenth
+)
+
+// SetFollow makes the step clock follow incoming MIDI clock. While following,
+// the sequencer only runs between the master's Start (or Continue) and Stop,
+// at the tempo set with SetExternalTempo.
+func (e *Engine) SetFollow(enabled bool) {
+	e.update(func(p *mixParams) {
+		p.follow = enabled
+		p.extTempo = 0
+	})
+}
+
+// Following reports whether the step clock follows incoming MIDI clock
+func (e *Engine) Following() bool {
+	return e.params.Load().follow
+}
+
+// SetExternalTempo sets the tempo measured from the clock master
+func (e *Engine) SetExternalTempo(bpm float64) {
+	if bpm <= 0 {
+		return
+	}
+	e.update(func(p *mixParams) {
+		p.extTempo = bpm
+	})
+}
+
+// ExternalClock queues a clock or transport message from the clock master.
+// The render loop applies queued messages at the start of each buffer.
+func (e *Engine) ExternalClock(ev ClockEvent) {
+	select {
+	case e.syncEvents <- ev:
+	default:
+	}
+}
+
+// ExternalPlaying reports whether the clock master's transport is running
+func (e *Engine) ExternalPlaying() bool {
+	return e.extPlaying.Load()
+}
+
+// followClock applies queued messages from the clock master and returns the
+// step length to play this buffer with: the master's tempo, nudged to pull
+// the playhead back into phase with the ticks received so far. It is called
+// by the render loop only.
+func (e *Engine) followClock(pattern *BeatPreset, stepLen int64) int64 {
+	for drained := false; !drained; {
+		select {
+		case ev := <-e.syncEvents:
+			switch ev.Kind {
+			case ClockStart:
+				e.locate(pattern, stepLen, 0)
+				e.extRunning, e.extArmed = false, true
+			case ClockContinue:
+				e.extArmed = !e.extRunning
+			case ClockStop:
+				e.extRunning, e.extArmed = false, false
+			case ClockSongPosition:
+				e.locate(pattern, stepLen, int64(ev.Position)*clocksPerSixteenth)
+			case ClockTick:
+				// The first tick after Start or Continue marks the position
+				// itself; playback begins on it
+				if e.extArmed {
+					e.extRunning, e.extArmed = true, false
+				} else if e.extRunning {
+					e.extTicks++
+				}
+			}
+		default:
+			drained = true
+		}
+	}
+	e.extPlaying.Store(e.extRunning)
+
+	if !e.extRunning {
+		return stepLen
+	}
+
+	drift := float64(e.extTicks) - e.ownTicks
+	if math.Abs(drift) >= relocateTicks {
+		e.locate(pattern, stepLen, e.extTicks)
+		return stepLen
+	}
+	nudge := math.Max(-maxCorrection, math.Min(maxCorrection, drift*correctionPerTick))
+	return int64(float64(stepLen) / (1 + nudge))
+}
+
+// locate moves the playhead to a song position in clock ticks. Positions
+// past the end of the pattern wrap around, as the pattern loops.
+func (e *Engine) locate(pattern *BeatPreset, stepLen int64, ticks int64) {
+	e.extTicks = ticks
+	e.ownTicks = float64(ticks)
+
+	steps := float64(ticks) * float64(pattern.StepsPerBeat()) / ClocksPerQuarter
+	whole := math.Floor(steps)
+	e.step = int(whole) % pattern.Steps()
+	e.stepPos = int64((steps - whole) * float64(stepLen))
+
+	// Clock output restarts from the new position on the next 16th
+	if e.clockRunning {
+		e.clockRunning = false
+		e.emitClock(ClockEvent{Kind: ClockStop, Frame: e.samplePos})
+	}
+	spb := int64(pattern.StepsPerBeat())
+	e.clockTick = int(ticks*spb%(int64(pattern.Steps())*ClocksPerQuarter)/spb) - 1
+	e.clockPhase = 1
+}
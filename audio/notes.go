@@ -0,0 +1,113 @@
+package audio
+
+import "math"
+
+// noteRelease is the per-sample envelope decay of a melodic voice after its
+// note is released, a fade of a few tens of milliseconds
+const noteRelease = 0.999
+
+// noteEvent is a note played from a MIDI controller, applied by the render
+// loop at the start of the next buffer
+type noteEvent struct {
+	channel  int
+	note     int
+	velocity int // 0 for Note Off
+}
+
+// melodic reports whether a channel's pitch follows the notes played on it.
+// Drum channels keep their sound whatever note triggers them.
+func melodic(channel int) bool {
+	switch channel {
+	case ChBass, ChLead1, ChLead2, ChPad:
+		return true
+	}
+	return false
+}
+
+// drone reports whether a channel sounds continuously until notes are played
+// on it
+func drone(channel int) bool {
+	switch channel {
+	case ChLead1, ChLead2, ChPad:
+		return true
+	}
+	return false
+}
+
+// noteFreq returns the frequency of a MIDI note number in equal temperament
+func noteFreq(note int) float64 {
+	return 440 * math.Pow(2, float64(note-69)/12)
+}
+
+// NoteOn plays a note on a channel over the running pattern. Drum and bass
+// channels are retriggered at the velocity; melodic channels also take the
+// note's pitch, which they keep until the next note. Leads and pad drone
+// until the first note played on them; from then on they sound at the
+// note's velocity only while it is held.
+func (e *Engine) NoteOn(channel, note, velocity int) {
+	if velocity <= 0 {
+		e.NoteOff(channel, note)
+		return
+	}
+	e.queueNote(noteEvent{channel: channel, note: note, velocity: min(velocity, MaxVelocity)})
+}
+
+// NoteOff releases a note. Melodic voices fade out if it is the last note
+// played on the channel; drum hits always ring out.
+func (e *Engine) NoteOff(channel, note int) {
+	e.queueNote(noteEvent{channel: channel, note: note})
+}
+
+func (e *Engine) queueNote(ev noteEvent) {
+	if ev.channel < 0 || ev.channel >= len(e.envelopes) || ev.note < 0 || ev.note > 127 {
+		return
+	}
+	select {
+	case e.noteEvents <- ev:
+	default:
+	}
+}
+
+// applyNotes triggers the notes queued since the last buffer. It is called
+// by the render loop only.
+func (e *Engine) applyNotes() {
+	for {
+		select {
+		case ev := <-e.noteEvents:
+			ch := ev.channel
+			if ev.velocity == 0 {
+				if melodic(ch) && e.heldNotes[ch] == ev.note {
+					e.heldNotes[ch] = -1
+					e.released[ch] = true
+				}
+				continue
+			}
+			e.trigger(ch, float64(ev.velocity)/DefaultVelocity)
+			if drone(ch) {
+				e.gated[ch] = true
+			}
+			if melodic(ch) {
+				e.pitches[ch] = noteFreq(ev.note)
+				e.heldNotes[ch] = ev.note
+			}
+		default:
+			return
+		}
+	}
+}
+
+// trigger restarts a channel's envelope at the given level
+func (e *Engine) trigger(channel int, level float64) {
+	e.envelopes[channel] = level
+	e.ages[channel] = 0
+	e.released[channel] = false
+}
+
+// pitch returns a channel's frequency: the last note played on it, or its
+// default frequency
+func (e *Engine) pitch(channel int, ch *ChannelState) float64 {
+	if e.pitches[channel] > 0 {
+		return e.pitches[channel]
+	}
+	return ch.Frequency
+}
@@ -0,0 +1,51 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+// padLevel mixes frames with only the pad audible and returns the peak level
+// of the last quarter of them
+func padLevel(e *Engine, frames int) float64 {
+	left, right := make([]float64, frames), make([]float64, frames)
+	e.mix(left, right)
+	peak := 0.0
+	for i := frames * 3 / 4; i < frames; i++ {
+		peak = math.Max(peak, math.Max(math.Abs(left[i]), math.Abs(right[i])))
+	}
+	return peak
+}
+
+func TestNoteOffReleasesDrone(t *testing.T) {
+	e := newEngine(8)
+	e.SetSmoothing(0)
+	for ch := range ChannelNames {
+		e.SetChannelMute(ch, ch != ChPad)
+	}
+
+	drone := padLevel(e, 4096)
+	if drone == 0 {
+		t.Fatal("pad is silent before any note is played")
+	}
+
+	e.NoteOn(ChPad, 57, 100)
+	held := padLevel(e, 4096)
+	if held == 0 {
+		t.Fatal("pad is silent while a note is held")
+	}
+	if again := padLevel(e, 4096); again < held/2 {
+		t.Errorf("held note fell from %.3f to %.3f", held, again)
+	}
+
+	// Releasing another note leaves the held one sounding
+	e.NoteOff(ChPad, 60)
+	if level := padLevel(e, 4096); level < held/2 {
+		t.Errorf("releasing a different note dropped the level to %.3f", level)
+	}
+
+	e.NoteOff(ChPad, 57)
+	if released := padLevel(e, 22050); released > held/100 {
+		t.Errorf("pad level is %.4f half a second after Note Off, held level was %.3f", released, held)
+	}
+}
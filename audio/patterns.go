@@ -0,0 +1,304 @@
+package audio
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"midi-mixer/jsonfile"
+)
+
+// BeatPreset contains patterns for all drums. The rows may be any length;
+// Resolution and Meter say how the steps line up with beats and bars.
+//
+// Each row entry is a MIDI-style velocity: 0 is a rest and 1-127 a hit of
+// that strength. Files without a version, such as hand-written ones and
+// the built-ins, may be plain 0/1 grids: if made only of 0s and 1s, their
+// hits are converted to DefaultVelocity on load. SavePattern writes the
+// current version, whose velocities are always taken as they are. Accent is an optional row
+// of 0/1 flags that boosts every instrument hitting on that step, like the
+// accent track of a classic drum machine.
+type BeatPreset struct {
+	ID          string `json:"-"`                 // File name without extension
+	Version     int    `json:"version,omitempty"` // File format, see patternVersion
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Resolution  int    `json:"resolution,omitempty"` // Steps per quarter note, 4 (16ths) if unset
+	Meter       string `json:"meter,omitempty"`      // Time signature such as "6/8", 4/4 if unset
+	Kick        []int  `json:"kick"`
+	Snare       []int  `json:"snare"`
+	HiHat       []int  `json:"hihat"`
+	Bass        []int  `json:"bass"`
+	Accent      []int  `json:"accent,omitempty"`
+}
+
+// Pattern rows that the step sequencer plays
+const (
+	RowKick = iota
+	RowSnare
+	RowHiHat
+	RowBass
+	NumRows
+)
+
+// RowAccent is the accent row's position below the drum rows in editors
+const RowAccent = NumRows
+
+// RowNames are the JSON keys of the pattern rows
+var RowNames = [NumRows]string{"kick", "snare", "hihat", "bass"}
+
+// Hit dynamics
+const (
+	DefaultVelocity = 100
+	MinVelocity     = 1
+	MaxVelocity     = 127
+	AccentBoost     = 1.3 // Gain applied to accented hits
+)
+
+// Velocity returns the strength of a hit, or 0 for a rest
+func (p BeatPreset) Velocity(row, step int) int {
+	hits := p.Row(row)
+	if step < 0 || step >= len(hits) {
+		return 0
+	}
+	return hits[step]
+}
+
+// Accented reports whether the step carries an accent
+func (p BeatPreset) Accented(step int) bool {
+	return step >= 0 && step < len(p.Accent) && p.Accent[step] != 0
+}
+
+// Row returns the hits for one of the pattern rows
+func (p BeatPreset) Row(row int) []int {
+	switch row {
+	case RowKick:
+		return p.Kick
+	case RowSnare:
+		return p.Snare
+	case RowHiHat:
+		return p.HiHat
+	case RowBass:
+		return p.Bass
+	}
+	return nil
+}
+
+// setRow replaces one of the pattern rows
+func (p *BeatPreset) setRow(row int, hits []int) {
+	switch row {
+	case RowKick:
+		p.Kick = hits
+	case RowSnare:
+		p.Snare = hits
+	case RowHiHat:
+		p.HiHat = hits
+	case RowBass:
+		p.Bass = hits
+	}
+}
+
+// expandGrid turns the hits of an unversioned plain 0/1 grid into
+// DefaultVelocity hits. Patterns with any other velocity are left as they
+// are.
+func (p *BeatPreset) expandGrid() {
+	for row := 0; row < NumRows; row++ {
+		for _, hit := range p.Row(row) {
+			if hit > 1 {
+				return
+			}
+		}
+	}
+	for row := 0; row < NumRows; row++ {
+		hits := p.Row(row)
+		for i := range hits {
+			hits[i] *= DefaultVelocity
+		}
+	}
+}
+
+// patternVersion is the pattern file format SavePattern writes. Version 2
+// files hold literal velocities; unversioned ones may be plain 0/1 grids.
+const patternVersion = 2
+
+// Default pattern timing
+const (
+	DefaultResolution = 4
+	DefaultMeter      = "4/4"
+	MaxResolution     = 32 // 128th notes
+	maxPatternSteps   = 256
+)
+
+// Steps returns the pattern length
+func (p BeatPreset) Steps() int {
+	return len(p.Kick)
+}
+
+// StepsPerBeat returns the number of steps in a quarter note
+func (p BeatPreset) StepsPerBeat() int {
+	if p.Resolution <= 0 {
+		return DefaultResolution
+	}
+	return p.Resolution
+}
+
+// TimeSignature returns the numerator and denominator of the meter
+func (p BeatPreset) TimeSignature() (int, int) {
+	meter := p.Meter
+	if meter == "" {
+		meter = DefaultMeter
+	}
+	var num, den int
+	if _, err := fmt.Sscanf(meter, "%d/%d", &num, &den); err != nil || num <= 0 || den <= 0 {
+		return 4, 4
+	}
+	return num, den
+}
+
+// StepsPerBar returns the number of steps in one bar of the meter. Tempo is
+// always counted in quarter notes, so a bar of 6/8 at 16ths has 12 steps.
+func (p BeatPreset) StepsPerBar() int {
+	num, den := p.TimeSignature()
+	return num * 4 * p.StepsPerBeat() / den
+}
+
+// validate checks that a pattern loaded from a file can be played
+func (p *BeatPreset) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if p.Version != 0 && p.Version != patternVersion {
+		return fmt.Errorf("version %d is not supported, want %d or none", p.Version, patternVersion)
+	}
+	if p.Resolution < 0 || p.Resolution > MaxResolution {
+		return fmt.Errorf("resolution must be 1 to %d, got %d", MaxResolution, p.Resolution)
+	}
+	if p.Meter != "" {
+		var num, den int
+		if _, err := fmt.Sscanf(p.Meter, "%d/%d", &num, &den); err != nil || num <= 0 || den <= 0 {
+			return fmt.Errorf("meter %q is not a time signature like 3/4", p.Meter)
+		}
+		if num*4*p.StepsPerBeat()%den != 0 {
+			return fmt.Errorf("meter %s does not divide into steps at resolution %d", p.Meter, p.StepsPerBeat())
+		}
+	}
+
+	steps := p.Steps()
+	if steps == 0 || steps > maxPatternSteps {
+		return fmt.Errorf("patterns need 1 to %d steps, got %d", maxPatternSteps, steps)
+	}
+	for row := 0; row < NumRows; row++ {
+		hits := p.Row(row)
+		if len(hits) != steps {
+			return fmt.Errorf("%s has %d steps, want %d like kick", RowNames[row], len(hits), steps)
+		}
+		for _, hit := range hits {
+			if hit < 0 || hit > MaxVelocity {
+				return fmt.Errorf("%s has velocity %d, want 0-%d", RowNames[row], hit, MaxVelocity)
+			}
+		}
+	}
+	if p.Accent != nil && len(p.Accent) != steps {
+		return fmt.Errorf("accent has %d steps, want %d like kick", len(p.Accent), steps)
+	}
+	for _, flag := range p.Accent {
+		if flag != 0 && flag != 1 {
+			return fmt.Errorf("accent has %d, want 0 or 1", flag)
+		}
+	}
+	return nil
+}
+
+// Sick beat presets for different vibes, kept as JSON alongside user patterns
+//
+//go:embed patterns/*.json
+var builtinPatterns embed.FS
+
+// parseBuiltins decodes the embedded presets once
+var parseBuiltins = sync.OnceValue(func() []BeatPreset {
+	patterns, err := jsonfile.ReadAll(builtinPatterns, "patterns", "pattern", parsePattern)
+	if err != nil {
+		panic(fmt.Sprintf("audio: built-in patterns are broken: %v", err))
+	}
+	return patterns
+})
+
+// DefaultPatterns returns the built-in beat presets
+func DefaultPatterns() []BeatPreset {
+	return append([]BeatPreset(nil), parseBuiltins()...)
+}
+
+// LoadPatterns returns the built-in presets extended with the *.json patterns
+// in dir. A user pattern whose file name matches a built-in one replaces it;
+// the rest are appended in file name order. A missing dir is not an error.
+func LoadPatterns(dir string) ([]BeatPreset, error) {
+	return jsonfile.Extend(DefaultPatterns(), dir, "pattern", parsePattern, func(p BeatPreset) string {
+		return p.ID
+	})
+}
+
+// parsePattern decodes and validates a pattern file named id
+func parsePattern(data []byte, id string) (BeatPreset, error) {
+	p := BeatPreset{ID: id}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, err
+	}
+	if err := p.validate(); err != nil {
+		return p, err
+	}
+	if p.Version == 0 {
+		p.expandGrid()
+	}
+	p.Version = patternVersion
+	return p, nil
+}
+
+// numberList matches a JSON array of numbers spread over several lines
+var numberList = regexp.MustCompile(`\[[\s\d,.-]+\]`)
+
+// SavePattern writes p to dir as <ID>.json, creating dir if needed, and
+// returns the file path. Patterns without an ID are named after their Name.
+// Rows are kept on one line each so patterns diff cleanly in git.
+func SavePattern(dir string, p BeatPreset) (string, error) {
+	id := p.ID
+	if id == "" {
+		id = patternID(p.Name)
+	}
+	p.Version = patternVersion
+
+	data, err := jsonfile.Marshal(p, numberList)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, id+".json")
+	return file, os.WriteFile(file, data, 0o644)
+}
+
+// patternID turns a pattern name into a file-friendly identifier
+func patternID(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	id := strings.TrimSuffix(b.String(), "-")
+	if id == "" {
+		id = "pattern"
+	}
+	return id
+}
@@ -0,0 +1,208 @@
+package audio
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidateResolution(t *testing.T) {
+	tests := []struct {
+		resolution int
+		ok         bool
+	}{
+		{0, true}, // Unset, 16ths
+		{3, true},
+		{MaxResolution, true},
+		{-1, false},
+		{MaxResolution + 1, false},
+		{20000, false},
+	}
+	for _, tt := range tests {
+		p := BeatPreset{
+			Name:       "test",
+			Resolution: tt.resolution,
+			Kick:       make([]int, 8),
+			Snare:      make([]int, 8),
+			HiHat:      make([]int, 8),
+			Bass:       make([]int, 8),
+		}
+		err := p.validate()
+		if tt.ok && err != nil {
+			t.Errorf("resolution %d: unexpected error %v", tt.resolution, err)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), "resolution")) {
+			t.Errorf("resolution %d: got error %v, want a resolution error", tt.resolution, err)
+		}
+	}
+}
+
+func TestStepLengthNeverZero(t *testing.T) {
+	if n := samplesPerStep(MaxBPM, 20000); n < 1 {
+		t.Fatalf("samplesPerStep = %d, want at least 1", n)
+	}
+
+	// An out-of-range pattern set directly on the engine must still mix
+	e := newEngine(8)
+	e.SetBPM(MaxBPM)
+	e.SetPatterns([]BeatPreset{{Name: "test", Resolution: 20000, Kick: []int{1, 0, 1}}})
+	left, right := make([]float64, 512), make([]float64, 512)
+	e.mix(left, right)
+	for i := range left {
+		if math.IsNaN(left[i]) || math.IsNaN(right[i]) {
+			t.Fatalf("frame %d is NaN", i)
+		}
+	}
+}
+
+func TestVelocitiesMeanWhatTheySay(t *testing.T) {
+	grid, err := parsePattern([]byte(`{"name": "grid", "kick": [1, 0], "snare": [0, 1], "hihat": [0, 0], "bass": [1, 1]}`), "grid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := grid.Velocity(RowKick, 0); v != DefaultVelocity {
+		t.Errorf("plain grid hit has velocity %d, want %d", v, DefaultVelocity)
+	}
+
+	mixed, err := parsePattern([]byte(`{"name": "mixed", "kick": [1, 0], "snare": [0, 90], "hihat": [0, 0], "bass": [0, 0]}`), "mixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := mixed.Velocity(RowKick, 0); v != 1 {
+		t.Errorf("velocity 1 next to other velocities reads as %d", v)
+	}
+
+	e := newEngine(8)
+	e.SetStep(RowSnare, 1, 1)
+	if v := e.CurrentPattern().Velocity(RowSnare, 1); v != 1 {
+		t.Errorf("SetStep(1) stored velocity %d", v)
+	}
+
+	for _, p := range DefaultPatterns() {
+		for row := range NumRows {
+			for _, hit := range p.Row(row) {
+				if hit != 0 && hit != DefaultVelocity {
+					t.Errorf("%s: built-in %s hit has velocity %d, want %d", p.ID, RowNames[row], hit, DefaultVelocity)
+				}
+			}
+		}
+	}
+}
+
+func TestSavePatternRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "patterns")
+	p := BeatPreset{
+		Name:        "My Groove!",
+		Description: "Saved from the editor",
+		Resolution:  3,
+		Meter:       "6/8",
+		Kick:        []int{100, 0, 0, 0, 0, 0},
+		Snare:       []int{0, 0, 0, 90, 0, 0},
+		HiHat:       []int{70, 1, 70, 1, 70, 127},
+		Bass:        []int{100, 0, 0, 0, 0, 100},
+		Accent:      []int{1, 0, 0, 0, 0, 0},
+	}
+
+	file, err := SavePattern(dir, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "my-groove.json"); file != want {
+		t.Errorf("saved to %s, want %s", file, want)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []string{"kick", "snare", "hihat", "bass", "accent"} {
+		line := regexp.MustCompile(`(?m)^  "` + row + `": \[[\d, ]+\],?$`)
+		if !line.Match(data) {
+			t.Errorf("%s row is not on one line:\n%s", row, data)
+		}
+	}
+
+	patterns, err := LoadPatterns(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != len(DefaultPatterns())+1 {
+		t.Fatalf("loaded %d patterns, want the %d built-ins and the saved one", len(patterns), len(DefaultPatterns()))
+	}
+	p.ID = "my-groove"
+	p.Version = patternVersion
+	if got := patterns[len(patterns)-1]; !reflect.DeepEqual(got, p) {
+		t.Errorf("loaded %+v, want %+v", got, p)
+	}
+
+	// Saving a built-in under its own ID replaces it on load
+	builtin := DefaultPatterns()[0]
+	builtin.Kick = append([]int(nil), builtin.Kick...)
+	builtin.Kick[1] = 55
+	if _, err := SavePattern(dir, builtin); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err = LoadPatterns(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(patterns[0], builtin) {
+		t.Errorf("edited built-in loaded as %+v, want %+v", patterns[0], builtin)
+	}
+}
+
+func TestAccentFlags(t *testing.T) {
+	_, err := parsePattern([]byte(`{"name": "loud", "kick": [1, 0], "snare": [0, 1], "hihat": [0, 0], "bass": [0, 0], "accent": [2, 0]}`), "loud")
+	if err == nil || !strings.Contains(err.Error(), "accent") {
+		t.Errorf("accent of 2 loaded with error %v, want an accent error", err)
+	}
+
+	// Accents set directly on the engine toggle off whatever their value
+	e := newEngine(8)
+	e.SetPatterns([]BeatPreset{{Name: "test", Kick: make([]int, 4), Accent: []int{2, 0, 0, 0}}})
+	e.ToggleAccent(0)
+	if e.CurrentPattern().Accented(0) {
+		t.Error("accent of 2 didn't toggle off")
+	}
+	e.ToggleAccent(0)
+	if got := e.CurrentPattern().Accent[0]; got != 1 {
+		t.Errorf("accent toggled on to %d, want 1", got)
+	}
+}
+
+func TestSavePatternKeepsVelocityOne(t *testing.T) {
+	// A pattern of nothing but velocity 1 hits looks like a plain grid, but
+	// the version SavePattern writes keeps it from being expanded on load
+	dir := t.TempDir()
+	p := BeatPreset{
+		Name:  "Whisper",
+		Kick:  []int{1, 0, 1, 0},
+		Snare: []int{0, 1, 0, 1},
+		HiHat: []int{1, 1, 1, 1},
+		Bass:  []int{0, 0, 0, 0},
+	}
+	if _, err := SavePattern(dir, p); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := LoadPatterns(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := patterns[len(patterns)-1]
+	for row := range NumRows {
+		for step, hit := range got.Row(row) {
+			if hit != p.Row(row)[step] {
+				t.Errorf("%s step %d reloaded as %d, want %d", RowNames[row], step, hit, p.Row(row)[step])
+			}
+		}
+	}
+
+	// Unknown versions aren't guessed at
+	if _, err := parsePattern([]byte(`{"version": 3, "name": "future", "kick": [1], "snare": [0], "hihat": [0], "bass": [0]}`), "future"); err == nil {
+		t.Error("version 3 pattern loaded")
+	}
+}
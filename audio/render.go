@@ -0,0 +1,128 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// renderChunk is the number of frames mixed per pass when rendering offline
+const renderChunk = 1024
+
+// RenderWAV renders the given number of bars of the current pattern, tempo
+// and channel mix to w as a stereo WAV file with a bit depth of 16 or 24.
+// Bars follow the pattern's meter. The render starts from step 0 on a
+// private copy of the engine, so it runs faster than real time without
+// disturbing live playback.
+func (e *Engine) RenderWAV(w io.Writer, bars, bitDepth int) error {
+	if bitDepth != 16 && bitDepth != 24 {
+		return fmt.Errorf("unsupported bit depth %d (want 16 or 24)", bitDepth)
+	}
+	if bars < 1 {
+		return fmt.Errorf("bars must be at least 1, got %d", bars)
+	}
+
+	r := e.offlineCopy()
+	pattern := r.CurrentPattern()
+	frames := bars * pattern.StepsPerBar() * samplesPerStep(float64(r.GetBPM()), pattern.StepsPerBeat())
+	bytesPerSample := bitDepth / 8
+
+	bw := bufio.NewWriter(w)
+	if err := writeWAVHeader(bw, frames, bitDepth); err != nil {
+		return err
+	}
+
+	left := make([]float64, renderChunk)
+	right := make([]float64, renderChunk)
+	out := make([]byte, renderChunk*channelCount*bytesPerSample)
+
+	for remaining := frames; remaining > 0; {
+		n := renderChunk
+		if remaining < n {
+			n = remaining
+		}
+		r.mix(left[:n], right[:n])
+
+		idx := 0
+		for i := 0; i < n; i++ {
+			idx = putSample(out, idx, left[i], bitDepth)
+			idx = putSample(out, idx, right[i], bitDepth)
+		}
+		if _, err := bw.Write(out[:idx]); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+
+	return bw.Flush()
+}
+
+// offlineCopy returns an output-less engine with the same mix, tempo and
+// pattern as e, positioned at the start of the pattern
+func (e *Engine) offlineCopy() *Engine {
+	p := *e.params.Load()
+	p.bpm = e.GetBPM()
+	p.clockOut = false
+	p.follow = false
+	p.transport = TransportPlaying
+
+	r := newEngine(len(p.channels))
+	r.params.Store(&p)
+	return r
+}
+
+// putSample encodes a mixed sample as little-endian PCM at buf[idx] and
+// returns the index following it
+func putSample(buf []byte, idx int, x float64, bitDepth int) int {
+	if bitDepth == 24 {
+		v := int32(x * 8388607 * outputGain)
+		buf[idx] = byte(v)
+		buf[idx+1] = byte(v >> 8)
+		buf[idx+2] = byte(v >> 16)
+		return idx + 3
+	}
+	v := int16(x * 32767 * outputGain)
+	buf[idx] = byte(v)
+	buf[idx+1] = byte(v >> 8)
+	return idx + 2
+}
+
+// wavHeader is the canonical 44-byte header of a PCM WAV file
+type wavHeader struct {
+	RIFF          [4]byte
+	ChunkSize     uint32
+	WAVE          [4]byte
+	Fmt           [4]byte
+	FmtSize       uint32
+	AudioFormat   uint16
+	NumChannels   uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	Data          [4]byte
+	DataSize      uint32
+}
+
+// writeWAVHeader writes the header for a stereo PCM stream of the given length
+func writeWAVHeader(w io.Writer, frames, bitDepth int) error {
+	blockAlign := channelCount * bitDepth / 8
+	dataSize := uint32(frames * blockAlign)
+
+	return binary.Write(w, binary.LittleEndian, wavHeader{
+		RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     36 + dataSize,
+		WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		AudioFormat:   1, // PCM
+		NumChannels:   channelCount,
+		SampleRate:    sampleRate,
+		ByteRate:      uint32(sampleRate * blockAlign),
+		BlockAlign:    uint16(blockAlign),
+		BitsPerSample: uint16(bitDepth),
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      dataSize,
+	})
+}
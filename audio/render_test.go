@@ -0,0 +1,61 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// readWAVHeader decodes the header at the start of a WAV file
+func readWAVHeader(t *testing.T, data []byte) wavHeader {
+	t.Helper()
+	var h wavHeader
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &h); err != nil {
+		t.Fatalf("reading WAV header: %v", err)
+	}
+	if string(h.RIFF[:]) != "RIFF" || string(h.WAVE[:]) != "WAVE" || string(h.Fmt[:]) != "fmt " || string(h.Data[:]) != "data" {
+		t.Fatalf("bad WAV chunk IDs %q %q %q %q", h.RIFF, h.WAVE, h.Fmt, h.Data)
+	}
+	return h
+}
+
+func TestRenderWAV(t *testing.T) {
+	for _, depth := range []int{16, 24} {
+		e := newEngine(8)
+		pattern := e.CurrentPattern()
+		frames := 2 * pattern.StepsPerBar() * samplesPerStep(DefaultBPM, pattern.StepsPerBeat())
+		blockAlign := channelCount * depth / 8
+
+		var buf bytes.Buffer
+		if err := e.RenderWAV(&buf, 2, depth); err != nil {
+			t.Fatalf("%d-bit: %v", depth, err)
+		}
+		h := readWAVHeader(t, buf.Bytes())
+
+		if h.AudioFormat != 1 || h.NumChannels != channelCount || h.SampleRate != sampleRate {
+			t.Errorf("%d-bit: format %d, %d channels at %d Hz, want PCM stereo at %d Hz", depth, h.AudioFormat, h.NumChannels, h.SampleRate, sampleRate)
+		}
+		if int(h.BitsPerSample) != depth || int(h.BlockAlign) != blockAlign || int(h.ByteRate) != sampleRate*blockAlign {
+			t.Errorf("%d-bit: %d bits, block align %d, byte rate %d", depth, h.BitsPerSample, h.BlockAlign, h.ByteRate)
+		}
+		if want := frames * blockAlign; int(h.DataSize) != want || int(h.ChunkSize) != 36+want {
+			t.Errorf("%d-bit: data size %d, chunk size %d, want %d and %d", depth, h.DataSize, h.ChunkSize, want, 36+want)
+		}
+		if got := buf.Len() - 44; got != frames*blockAlign {
+			t.Errorf("%d-bit: wrote %d frames, want %d", depth, got/blockAlign, frames)
+		}
+		if bytes.Count(buf.Bytes()[44:], []byte{0}) == buf.Len()-44 {
+			t.Errorf("%d-bit: render is silent", depth)
+		}
+	}
+}
+
+func TestRenderWAVRejectsBadArguments(t *testing.T) {
+	e := newEngine(8)
+	if err := e.RenderWAV(&bytes.Buffer{}, 1, 8); err == nil {
+		t.Error("8-bit render succeeded")
+	}
+	if err := e.RenderWAV(&bytes.Buffer{}, 0, 16); err == nil {
+		t.Error("render of 0 bars succeeded")
+	}
+}
@@ -0,0 +1,193 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is an output backend that pulls 16-bit stereo PCM from the engine
+type Sink interface {
+	// Start begins pulling audio from r until the sink is closed
+	Start(r io.Reader) error
+	Close() error
+	String() string
+}
+
+// Format of the PCM stream sinks pull: 16-bit little-endian stereo
+const (
+	SampleRate     = sampleRate
+	Channels       = channelCount
+	BytesPerSample = bitDepth
+)
+
+// OpenSink creates a sink from a startup spec: "null" to discard output, or
+// "wav:FILE" / "raw:FILE" to record the live output to a file. Sound card
+// output lives in the program, so this package builds without audio
+// libraries or cgo.
+func OpenSink(spec string) (Sink, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "null":
+		return NewNullSink(), nil
+	case "wav", "raw":
+		if path == "" {
+			return nil, fmt.Errorf("output %q needs a file name, e.g. %s:out.%s", spec, kind, kind)
+		}
+		return NewFileSink(path, kind == "wav"), nil
+	}
+	return nil, fmt.Errorf("unknown audio output %q (want oto, null, wav:FILE or raw:FILE)", spec)
+}
+
+// pacedSink pulls audio in real time and hands it to write. It keeps the
+// sequencer and visualizers moving when there is no audio device to clock it.
+type pacedSink struct {
+	write   func([]byte) error
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	started bool
+	err     error
+}
+
+// pacedChunk is the amount of audio pulled per tick by paced sinks
+const pacedChunk = 10 * time.Millisecond
+
+func newPacedSink(write func([]byte) error) *pacedSink {
+	return &pacedSink{
+		write: write,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Start launches the real-time pull loop
+func (s *pacedSink) Start(r io.Reader) error {
+	s.started = true
+	go s.run(r)
+	return nil
+}
+
+func (s *pacedSink) run(r io.Reader) {
+	defer close(s.done)
+
+	frames := int(sampleRate * pacedChunk / time.Second)
+	buf := make([]byte, frames*channelCount*bitDepth)
+
+	ticker := time.NewTicker(pacedChunk)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if _, err := io.ReadFull(r, buf); err != nil {
+				s.err = err
+				return
+			}
+			if err := s.write(buf); err != nil {
+				s.err = err
+				return
+			}
+		}
+	}
+}
+
+// Close stops the pull loop and returns the first error it hit
+func (s *pacedSink) Close() error {
+	if !s.started {
+		return nil
+	}
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+	return s.err
+}
+
+// NullSink discards output while still running the engine in real time
+type NullSink struct {
+	*pacedSink
+}
+
+// NewNullSink creates a sink for headless machines and tests
+func NewNullSink() *NullSink {
+	return &NullSink{newPacedSink(func([]byte) error { return nil })}
+}
+
+func (s *NullSink) String() string {
+	return "null"
+}
+
+// FileSink records the live output to a raw PCM or WAV file
+type FileSink struct {
+	*pacedSink
+	path   string
+	wav    bool
+	file   *os.File
+	frames int
+}
+
+// NewFileSink creates a sink writing to path, with a WAV header if wav is set
+func NewFileSink(path string, wav bool) *FileSink {
+	s := &FileSink{path: path, wav: wav}
+	s.pacedSink = newPacedSink(s.writeChunk)
+	return s
+}
+
+// Start creates the output file and begins recording
+func (s *FileSink) Start(r io.Reader) error {
+	f, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	s.file = f
+
+	if s.wav {
+		// Sizes are patched in on Close once the length is known
+		if err := writeWAVHeader(f, 0, bitDepth*8); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return s.pacedSink.Start(r)
+}
+
+func (s *FileSink) writeChunk(buf []byte) error {
+	s.frames += len(buf) / (channelCount * bitDepth)
+	_, err := s.file.Write(buf)
+	return err
+}
+
+// Close stops recording and finalizes the file
+func (s *FileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.pacedSink.Close()
+
+	if s.wav {
+		if _, serr := s.file.Seek(0, io.SeekStart); serr == nil {
+			if herr := writeWAVHeader(s.file, s.frames, bitDepth*8); err == nil {
+				err = herr
+			}
+		} else if err == nil {
+			err = serr
+		}
+	}
+
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
+
+func (s *FileSink) String() string {
+	if s.wav {
+		return "wav:" + s.path
+	}
+	return "raw:" + s.path
+}
@@ -0,0 +1,71 @@
+package audio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenSink(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string // String of the sink, empty for an error
+	}{
+		{"null", "null"},
+		{"wav:out.wav", "wav:out.wav"},
+		{"raw:dir/out.pcm", "raw:dir/out.pcm"},
+		{"wav", ""},
+		{"raw:", ""},
+		{"alsa", ""},
+		{"oto", ""}, // Opened by the program, not the package
+	}
+	for _, tt := range tests {
+		sink, err := OpenSink(tt.spec)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("OpenSink(%q) = %v, want an error", tt.spec, sink)
+		case tt.want != "" && err != nil:
+			t.Errorf("OpenSink(%q): %v", tt.spec, err)
+		case tt.want != "" && sink.String() != tt.want:
+			t.Errorf("OpenSink(%q) = %v, want %s", tt.spec, sink, tt.want)
+		}
+	}
+}
+
+func TestFileSinkPatchesWAVHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "live.wav")
+	sink, err := OpenSink("wav:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(8, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wait for some audio to be recorded
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(pacedChunk) {
+		if info, err := os.Stat(path); err == nil && info.Size() > 44 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("nothing recorded")
+		}
+	}
+	e.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := readWAVHeader(t, data)
+	if h.DataSize == 0 {
+		t.Fatal("header still claims no data after Close")
+	}
+	if int(h.DataSize) != len(data)-44 || int(h.ChunkSize) != len(data)-8 {
+		t.Errorf("header says %d data bytes in a %d-byte chunk, file has %d bytes of data", h.DataSize, h.ChunkSize, len(data)-44)
+	}
+	if int(h.BitsPerSample) != bitDepth*8 || h.NumChannels != channelCount {
+		t.Errorf("header says %d-bit with %d channels, want %d-bit stereo", h.BitsPerSample, h.NumChannels, bitDepth*8)
+	}
+}
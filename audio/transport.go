@@ -0,0 +1,74 @@
+package audio
+
+import "time"
+
+// Transport is the play state of the sequencer
+type Transport int
+
+const (
+	TransportStopped Transport = iota
+	TransportPlaying
+	TransportPaused
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportPlaying:
+		return "playing"
+	case TransportPaused:
+		return "paused"
+	}
+	return "stopped"
+}
+
+// transportFade is the time constant of the fade when the sequencer stops or
+// pauses, long enough not to click however short the gain smoothing is
+const transportFade = 20 * time.Millisecond
+
+// Play starts the sequencer, from step 0 when stopped or from where it left
+// off when paused
+func (e *Engine) Play() {
+	e.setTransport(TransportPlaying)
+}
+
+// Pause halts the sequencer in place
+func (e *Engine) Pause() {
+	e.update(func(p *mixParams) {
+		if p.transport == TransportPlaying {
+			p.transport = TransportPaused
+		}
+	})
+}
+
+// Stop halts the sequencer and rewinds it to step 0
+func (e *Engine) Stop() {
+	e.setTransport(TransportStopped)
+}
+
+// Restart plays the pattern from step 0, whatever the transport state
+func (e *Engine) Restart() {
+	e.update(func(p *mixParams) {
+		p.transport = TransportPlaying
+		p.restarts++
+	})
+}
+
+func (e *Engine) setTransport(t Transport) {
+	e.update(func(p *mixParams) {
+		p.transport = t
+	})
+}
+
+// Transport returns the play state. While following an external clock it is
+// the clock master's: playing between Start or Continue and Stop, paused
+// otherwise.
+func (e *Engine) Transport() Transport {
+	p := e.params.Load()
+	if !p.follow {
+		return p.transport
+	}
+	if e.extPlaying.Load() {
+		return TransportPlaying
+	}
+	return TransportPaused
+}
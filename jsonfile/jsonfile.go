@@ -0,0 +1,93 @@
+// Package jsonfile reads and writes the mixer's JSON libraries, such as beat
+// patterns and controller profiles: directories of *.json files, bundled
+// ones extended by the user's own.
+package jsonfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ReadAll decodes every *.json file in dir of fsys with parse, sorted by
+// file name. parse is given the file's contents and its name without the
+// extension. Files that fail to parse are skipped and reported in the
+// returned error, as the kind of file followed by its name.
+func ReadAll[T any](fsys fs.FS, dir, kind string, parse func(data []byte, id string) (T, error)) ([]T, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var items []T
+	var errs []error
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err == nil {
+			var item T
+			if item, err = parse(data, strings.TrimSuffix(name, ".json")); err == nil {
+				items = append(items, item)
+				continue
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s %s: %w", kind, name, err))
+	}
+	return items, errors.Join(errs...)
+}
+
+// Extend returns base extended with the *.json files in dir, decoded by
+// parse as for ReadAll. A file whose item has the id of one in base replaces
+// it; the rest are appended in file name order. A missing dir is not an
+// error.
+func Extend[T any](base []T, dir, kind string, parse func(data []byte, id string) (T, error), id func(T) string) ([]T, error) {
+	user, err := ReadAll(os.DirFS(dir), ".", kind, parse)
+	if errors.Is(err, fs.ErrNotExist) {
+		return base, nil
+	}
+
+	for _, item := range user {
+		replaced := false
+		for i := range base {
+			if id(base[i]) == id(item) {
+				base[i] = item
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			base = append(base, item)
+		}
+	}
+	return base, err
+}
+
+// compactBrackets drops the spaces just inside brackets and braces
+var compactBrackets = strings.NewReplacer("[ ", "[", " ]", "]", "{ ", "{", " }", "}")
+
+// Marshal encodes v as indented JSON ending in a newline, putting each
+// match of oneLine, such as a pattern row, back on one line so files stay
+// readable and diff cleanly
+func Marshal(v any, oneLine *regexp.Regexp) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	data = oneLine.ReplaceAllFunc(data, func(match []byte) []byte {
+		return []byte(compactBrackets.Replace(strings.Join(strings.Fields(string(match)), " ")))
+	})
+	return append(data, '\n'), nil
+}
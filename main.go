@@ -1,40 +1,68 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	_ "gitlab.com/gomidi/midi/v2/drivers/rtmididrv" // System MIDI ports
 
+	"midi-mixer/audio"
 	"midi-mixer/midi"
 	"midi-mixer/mixer"
 	"midi-mixer/ui"
 )
 
+// bounceBars is the number of bars written by the bounce key
+const bounceBars = 4
+
+// portsInterval is how often MIDI ports are checked for devices plugged in
+// or dropping out
+const portsInterval = time.Second
+
 // View represents the current screen
 type View int
 
 const (
 	ViewMixer View = iota
 	ViewDevices
+	ViewMonitor
 )
 
 // Model is the main application model
 type Model struct {
 	state          *mixer.State
 	deviceSelector *ui.DeviceSelector
+	monitor        *ui.Monitor
 	currentView    View
 	width          int
 	height         int
 	err            error
+	notice         string
 	waveformL      []float64
 	waveformR      []float64
 }
 
-// MidiMsg is sent when a MIDI CC message is received
-type MidiMsg midi.CCMessage
+// MidiMsg is sent with the MIDI CC messages received since the last one
+type MidiMsg []midi.CCMessage
+
+// MCUMsg is sent when a Mackie Control surface input is received
+type MCUMsg midi.MCUMessage
+
+// NoteMsg is sent when a note bound to a mixer control is received
+type NoteMsg midi.NoteMessage
+
+// ProgramMsg is sent when a MIDI Program Change is received
+type ProgramMsg midi.ProgramMessage
+
+// PortsMsg is sent when MIDI ports appear or disappear
+type PortsMsg midi.PortsMessage
 
 // TickMsg triggers waveform updates
 type TickMsg time.Time
@@ -43,6 +71,10 @@ type TickMsg time.Time
 func (m Model) Init() tea.Cmd {
 	return tea.Batch(
 		listenForMidi(m.state.MidiHandler),
+		listenForNotes(m.state),
+		listenForMCU(m.state.MidiHandler),
+		listenForPrograms(m.state.MidiHandler),
+		listenForPorts(m.state.MidiHandler),
 		tickCmd(),
 	)
 }
@@ -57,8 +89,39 @@ func tickCmd() tea.Cmd {
 // listenForMidi creates a command that listens for MIDI messages
 func listenForMidi(handler *midi.Handler) tea.Cmd {
 	return func() tea.Msg {
-		msg := <-handler.CCChannel()
-		return MidiMsg(msg)
+		return MidiMsg(handler.NextCC())
+	}
+}
+
+// listenForMCU creates a command that listens for Mackie Control input
+func listenForMCU(handler *midi.Handler) tea.Cmd {
+	return func() tea.Msg {
+		msg := <-handler.MCUChannel()
+		return MCUMsg(msg)
+	}
+}
+
+// listenForPrograms creates a command that listens for program changes
+func listenForPrograms(handler *midi.Handler) tea.Cmd {
+	return func() tea.Msg {
+		msg := <-handler.ProgramChannel()
+		return ProgramMsg(msg)
+	}
+}
+
+// listenForPorts creates a command that listens for MIDI port changes
+func listenForPorts(handler *midi.Handler) tea.Cmd {
+	return func() tea.Msg {
+		msg := <-handler.PortsChannel()
+		return PortsMsg(msg)
+	}
+}
+
+// listenForNotes creates a command that listens for notes bound to controls
+func listenForNotes(state *mixer.State) tea.Cmd {
+	return func() tea.Msg {
+		msg := <-state.BoundNotes()
+		return NoteMsg(msg)
 	}
 }
 
@@ -78,12 +141,33 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.state.AudioEngine != nil {
 			m.waveformL, m.waveformR = m.state.AudioEngine.GetWaveform()
 		}
+		m.state.SendFeedback()
 		return m, tickCmd()
 
 	case MidiMsg:
-		m.handleMidiCC(midi.CCMessage(msg))
+		for _, cc := range msg {
+			m.handleMidiCC(cc)
+		}
 		return m, listenForMidi(m.state.MidiHandler)
 
+	case MCUMsg:
+		m.state.HandleMCU(midi.MCUMessage(msg))
+		return m, listenForMCU(m.state.MidiHandler)
+
+	case ProgramMsg:
+		if err := m.state.HandleProgram(midi.ProgramMessage(msg)); err != nil {
+			m.err = err
+		}
+		return m, listenForPrograms(m.state.MidiHandler)
+
+	case NoteMsg:
+		m.handleNote(midi.NoteMessage(msg))
+		return m, listenForNotes(m.state)
+
+	case PortsMsg:
+		m.handlePorts(midi.PortsMessage(msg))
+		return m, listenForPorts(m.state.MidiHandler)
+
 	case error:
 		m.err = msg
 		return m, nil
@@ -99,12 +183,103 @@ func (m Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m.handleMixerKeys(msg)
 	case ViewDevices:
 		return m.handleDeviceKeys(msg)
+	case ViewMonitor:
+		return m.handleMonitorKeys(msg)
 	}
 	return m, nil
 }
 
+// handleEditKeys handles keyboard input while editing the beat grid. Keys
+// that have no meaning in the grid fall through to the mixer bindings.
+func (m Model) handleEditKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "left", "h":
+		m.state.MoveEditCursor(0, -1)
+
+	case "right", "l":
+		m.state.MoveEditCursor(0, 1)
+
+	case "up", "k":
+		m.state.MoveEditCursor(-1, 0)
+
+	case "down", "j":
+		m.state.MoveEditCursor(1, 0)
+
+	case " ", "enter", "x":
+		m.state.ToggleStep()
+
+	case "a":
+		m.state.ToggleAccent()
+
+	case "[":
+		m.state.AdjustStepVelocity(-10)
+
+	case "]":
+		m.state.AdjustStepVelocity(10)
+
+	case "{":
+		m.state.AdjustStepVelocity(-1)
+
+	case "}":
+		m.state.AdjustStepVelocity(1)
+
+	case "e", "esc":
+		m.state.ToggleEditMode()
+
+	default:
+		return m.handlePlayKeys(msg)
+	}
+
+	return m, nil
+}
+
+// handleLearnKeys handles keyboard input while learning MIDI bindings. Keys
+// that have no meaning in learn mode fall through to the mixer bindings.
+func (m Model) handleLearnKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "left", "h":
+		m.state.SelectPrev()
+
+	case "right", "l":
+		m.state.SelectNext()
+
+	case "up", "k", "shift+tab":
+		m.state.CycleLearnTarget(-1)
+
+	case "down", "j", "tab":
+		m.state.CycleLearnTarget(1)
+
+	case "backspace", "delete":
+		// Forget the target's binding
+		if err := m.state.Unbind(); err != nil {
+			m.err = err
+		} else {
+			m.notice = "Unbound " + m.state.TargetName(m.state.LearnTarget())
+		}
+
+	case "L", "esc":
+		m.state.StopLearn()
+
+	default:
+		return m.handlePlayKeys(msg)
+	}
+
+	return m, nil
+}
+
 // handleMixerKeys handles keyboard input in mixer view
 func (m Model) handleMixerKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.state.Learning {
+		return m.handleLearnKeys(msg)
+	}
+	if m.state.EditMode {
+		return m.handleEditKeys(msg)
+	}
+	return m.handlePlayKeys(msg)
+}
+
+// handlePlayKeys handles the mixer bindings outside of beat editing
+func (m Model) handlePlayKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "q", "ctrl+c":
 		m.state.Close()
@@ -146,10 +321,37 @@ func (m Model) handleMixerKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "s":
 		m.state.ToggleSolo()
 
+	case "e":
+		m.state.ToggleEditMode()
+
+	case "ctrl+s":
+		// Save the current pattern, including edits, to the pattern library
+		if path, err := m.state.SavePattern(); err != nil {
+			m.err = err
+		} else {
+			m.notice = "Saved pattern to " + path
+		}
+
 	case "d":
-		m.deviceSelector = ui.NewDeviceSelector()
+		names := make([]string, len(m.state.Profiles), len(m.state.Profiles)+1)
+		for i, p := range m.state.Profiles {
+			names[i] = p.Name
+		}
+		names = append(names, mixer.MCUMapping)
+		defaultMapping := m.state.ProfileIndex("")
+		if m.state.MidiHandler.MCU() {
+			defaultMapping = len(m.state.Profiles)
+		}
+		m.deviceSelector = ui.NewDeviceSelector(names, defaultMapping, m.connectedDevices())
 		m.currentView = ViewDevices
 
+	case "i":
+		// Open the MIDI monitor, keeping its filters from last time
+		if m.monitor == nil {
+			m.monitor = ui.NewMonitor()
+		}
+		m.currentView = ViewMonitor
+
 	case "p", "P":
 		// Cycle through beat patterns
 		m.state.NextPattern()
@@ -174,11 +376,52 @@ func (m Model) handleMixerKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		// Fine BPM decrease
 		m.state.AdjustBPM(-1)
 
+	case "b":
+		// Bounce the current mix to a WAV file
+		path := fmt.Sprintf("bounce-%s.wav", time.Now().Format("20060102-150405"))
+		if err := m.state.Bounce(path, bounceBars, 16); err != nil {
+			m.err = err
+		} else {
+			m.notice = fmt.Sprintf("Bounced %d bars to %s", bounceBars, path)
+		}
+
+	case "g":
+		// More swing
+		m.state.AdjustSwing(5)
+
+	case "G":
+		// Less swing
+		m.state.AdjustSwing(-5)
+
+	case " ":
+		// Play or pause the sequencer
+		m.state.TogglePlay()
+
+	case "x":
+		// Stop and rewind to the first step
+		m.state.Stop()
+
+	case "r":
+		// Restart the pattern from the first step
+		m.state.Restart()
+
+	case "c":
+		// Toggle MIDI clock output
+		m.state.ToggleClockOutput()
+
+	case "f":
+		// Toggle following incoming MIDI clock
+		m.state.ToggleFollow()
+
+	case "L":
+		// Learn a MIDI controller binding
+		m.state.StartLearn()
+
 	case "0":
 		// Reset selected channel to defaults
 		if ch := m.state.SelectedChannel(); ch != nil {
-			ch.Volume = 100
-			ch.Pan = 64
+			ch.Volume = mixer.DefaultVolume
+			ch.Pan = mixer.DefaultPan
 			ch.Mute = false
 			ch.Solo = false
 		}
@@ -206,14 +449,50 @@ func (m Model) handleDeviceKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "tab":
 		m.deviceSelector.ToggleFocus()
 
+	case "left", "h":
+		m.deviceSelector.AdjustChannel(-1)
+
+	case "right", "l":
+		m.deviceSelector.AdjustChannel(1)
+
 	case "r":
 		m.deviceSelector.Refresh()
 
+	case "delete", "backspace":
+		if d, ok := m.deviceSelector.GetSelectedConnected(); ok {
+			m.state.Disconnect(d.ID)
+			m.deviceSelector.SetConnected(m.connectedDevices())
+		}
+
 	case "enter":
 		inPort := m.deviceSelector.GetSelectedInput()
 		outPort := m.deviceSelector.GetSelectedOutput()
-		if err := m.state.MidiHandler.Connect(inPort, outPort); err != nil {
+		if inPort == nil && outPort == nil {
+			return m, nil
+		}
+		id, err := m.state.Connect(inPort, outPort)
+		if err != nil {
 			m.err = err
+			return m, nil
+		}
+		m.state.SetDeviceChannel(id, m.deviceSelector.Channel)
+
+		mapping := m.deviceSelector.SelectedProfile
+		m.state.SetDeviceMCU(id, mapping == len(m.state.Profiles))
+		// The default mapping clears any bindings remembered for the device
+		switch {
+		case mapping == m.state.ProfileIndex(""):
+			err = m.state.UseDefaultBindings(id)
+		case mapping >= 0 && mapping < len(m.state.Profiles):
+			err = m.state.UseProfile(id, mapping)
+		}
+		if err != nil {
+			m.err = err
+		}
+		for _, d := range m.state.Devices() {
+			if d.ID == id {
+				m.notice = fmt.Sprintf("Connected %s using %s", d.Name(), m.state.DeviceMapping(d))
+			}
 		}
 		m.currentView = ViewMixer
 	}
@@ -221,19 +500,100 @@ func (m Model) handleDeviceKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// handleMonitorKeys handles keyboard input in the MIDI monitor view
+func (m Model) handleMonitorKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		m.state.Close()
+		return m, tea.Quit
+
+	case "esc":
+		m.currentView = ViewMixer
+
+	case "tab":
+		m.monitor.CycleKind(1)
+
+	case "shift+tab":
+		m.monitor.CycleKind(-1)
+
+	case "left", "h":
+		m.monitor.AdjustChannel(-1)
+
+	case "right", "l":
+		m.monitor.AdjustChannel(1)
+
+	case "i":
+		m.monitor.CycleDirection()
+
+	case "c":
+		// Clock is only logged while shown, so it can't flood out the rest
+		m.monitor.ToggleClock()
+		m.state.MidiHandler.SetMonitorClock(m.monitor.ShowClock)
+
+	case " ":
+		m.monitor.TogglePause(m.state.MidiHandler.Monitor())
+
+	case "up", "k":
+		m.monitor.ScrollBy(1, len(m.monitor.Filter(m.state.MidiHandler.Monitor())))
+
+	case "down", "j":
+		m.monitor.ScrollBy(-1, len(m.monitor.Filter(m.state.MidiHandler.Monitor())))
+
+	case "x":
+		m.state.MidiHandler.ClearMonitor()
+		if m.monitor.Paused() {
+			m.monitor.TogglePause(nil)
+		}
+	}
+
+	return m, nil
+}
+
+// connectedDevices lists the connected MIDI devices for the device screen,
+// followed by those lost
+func (m Model) connectedDevices() []ui.ConnectedDevice {
+	var connected []ui.ConnectedDevice
+	for _, d := range m.state.Devices() {
+		connected = append(connected, ui.ConnectedDevice{Device: d, Mapping: m.state.DeviceMapping(d)})
+	}
+	for _, d := range m.state.LostDevices() {
+		connected = append(connected, ui.ConnectedDevice{Device: d, Mapping: m.state.DeviceMapping(d), Lost: true})
+	}
+	return connected
+}
+
+// handlePorts reconnects and drops devices as MIDI ports come and go
+func (m *Model) handlePorts(msg midi.PortsMessage) {
+	lost, found := m.state.HandlePorts(msg)
+	switch {
+	case len(found) > 0:
+		m.notice = "Reconnected " + strings.Join(found, ", ")
+	case len(lost) > 0:
+		m.notice = "Lost MIDI device " + strings.Join(lost, ", ")
+	}
+	if m.deviceSelector != nil {
+		m.deviceSelector.SetPorts(msg.Ins, msg.Outs)
+		m.deviceSelector.SetConnected(m.connectedDevices())
+	}
+}
+
 // handleMidiCC processes incoming MIDI CC messages
 func (m *Model) handleMidiCC(msg midi.CCMessage) {
-	// Map MIDI channel to mixer channel
-	chIdx := int(msg.Channel)
-	if chIdx >= len(m.state.Channels) {
-		return
+	b, err := m.state.HandleCC(msg)
+	if err != nil {
+		m.err = err
+	} else if b != nil {
+		m.notice = fmt.Sprintf("Bound %s to %s", b, m.state.TargetName(b.Target))
 	}
+}
 
-	switch msg.Controller {
-	case midi.CCVolume:
-		m.state.SetChannelVolume(chIdx, msg.Value)
-	case midi.CCPan:
-		m.state.SetChannelPan(chIdx, msg.Value)
+// handleNote processes incoming notes bound to controls or being learned
+func (m *Model) handleNote(msg midi.NoteMessage) {
+	b, err := m.state.HandleNote(msg)
+	if err != nil {
+		m.err = err
+	} else if b != nil {
+		m.notice = fmt.Sprintf("Bound %s to %s", b, m.state.TargetName(b.Target))
 	}
 }
 
@@ -246,6 +606,8 @@ func (m Model) View() string {
 		content = m.renderMixerView()
 	case ViewDevices:
 		content = m.renderDevicesView()
+	case ViewMonitor:
+		content = ui.RenderMonitor(m.monitor, m.state.MidiHandler.Monitor(), max(5, m.height-12))
 	}
 
 	// Center content
@@ -260,14 +622,19 @@ func (m Model) View() string {
 func (m Model) renderMixerView() string {
 	var sections []string
 
-	// Title with current BPM
+	// Title with current BPM and swing
 	bpm := m.state.GetBPM()
-	title := ui.TitleStyle.Render(fmt.Sprintf("🎛️  MIDI MIXER  ─  %d BPM", bpm))
+	swing := "STRAIGHT"
+	if s := m.state.GetSwing(); s > 0 {
+		swing = fmt.Sprintf("SWING %d%%", s)
+	}
+	title := ui.TitleStyle.Render(fmt.Sprintf("🎛️  MIDI MIXER  ─  %s  ─  %d BPM  ─  %s",
+		ui.TransportLabel(m.state.Transport()), bpm, swing))
 	sections = append(sections, title)
 
 	// Current pattern info
-	patternIdx := m.state.GetPatternIndex()
-	sections = append(sections, ui.RenderPatternInfo(patternIdx))
+	pattern := m.state.CurrentPattern()
+	sections = append(sections, ui.RenderPatternInfo(pattern))
 	sections = append(sections, "")
 
 	// Error message if any
@@ -276,9 +643,27 @@ func (m Model) renderMixerView() string {
 		sections = append(sections, errStyle.Render(fmt.Sprintf("Error: %v", m.err)))
 	}
 
+	if m.notice != "" {
+		noticeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#22C55E"))
+		sections = append(sections, noticeStyle.Render(m.notice))
+	}
+
+	if m.state.Learning {
+		target := m.state.LearnTarget()
+		bound := ""
+		if b, ok := m.state.BindingFor(target); ok {
+			bound = b.String()
+		}
+		sections = append(sections, ui.RenderLearnBar(m.state.TargetName(target), bound))
+	}
+
 	// Step sequencer visualization
 	currentStep := m.state.GetCurrentStep()
-	sections = append(sections, ui.RenderStepSequencer(patternIdx, currentStep))
+	editRow := -1
+	if m.state.EditMode {
+		editRow = m.state.EditRow
+	}
+	sections = append(sections, ui.RenderStepSequencer(pattern, currentStep, editRow, m.state.EditStep))
 	sections = append(sections, "")
 
 	// Waveform visualizer
@@ -312,14 +697,104 @@ func (m Model) renderDevicesView() string {
 	return ui.RenderDeviceSelector(m.deviceSelector)
 }
 
+// connectVirtual creates the mixer's virtual MIDI ports and connects to them
+func connectVirtual(state *mixer.State, name string) error {
+	in, out, err := midi.OpenVirtualPorts(name)
+	if err != nil {
+		return fmt.Errorf("virtual MIDI ports: %w", err)
+	}
+	_, err = state.Connect(in, out)
+	return err
+}
+
+// configDir returns the directory holding the mixer's user files
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "midi-mixer"
+	}
+	return filepath.Join(dir, "midi-mixer")
+}
+
 func main() {
+	renderPath := flag.String("render", "", "render the mix to a WAV `file` and exit")
+	bars := flag.Int("bars", 4, "number of bars to render with -render")
+	bits := flag.Int("bit-depth", 16, "WAV bit depth for -render (16 or 24)")
+	pattern := flag.Int("pattern", 1, "beat pattern number to start with")
+	bpm := flag.Int("bpm", audio.DefaultBPM, "tempo in beats per minute")
+	output := flag.String("output", "oto", "audio output: oto, null, wav:FILE or raw:FILE")
+	smoothing := flag.Duration("smoothing", audio.DefaultSmoothing, "ramp time for fader, pan, mute and solo changes (0 = instant)")
+	patternDir := flag.String("patterns", filepath.Join(configDir(), "patterns"), "directory of JSON beat patterns to load and save")
+	profileDir := flag.String("profiles", filepath.Join(configDir(), "profiles"), "directory of JSON controller mapping profiles")
+	bindings := flag.String("bindings", filepath.Join(configDir(), "bindings.json"), "`file` of learned MIDI controller bindings")
+	noteMap := flag.String("notes", mixer.DefaultNoteMap, "map MIDI notes to channels as channel=note or channel=low-high, comma separated")
+	follow := flag.Bool("follow", false, "follow tempo and transport of MIDI clock on the MIDI input")
+	mcu := flag.Bool("mcu", false, "connect MIDI devices as Mackie Control (MCU) surfaces by default instead of CC bindings")
+	virtual := flag.String("virtual", "", "create virtual MIDI in and out ports with this `name` and connect to them")
+	programChannel := flag.Int("program-channel", 0, "MIDI `channel` whose Program Changes select patterns and that pattern changes are sent on (0 = off)")
+	midiDriver := flag.String("midi-driver", "rtmidi", "MIDI driver: rtmidi, or loopback for in-process ports on machines without MIDI")
+	clockOut := flag.Bool("clock-out", false, "send MIDI clock, start/stop and song position to the MIDI output")
+	flag.Parse()
+
+	if *renderPath != "" {
+		state := mixer.NewOfflineState(8)
+		if err := state.LoadPatterns(*patternDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		state.SetPattern(*pattern - 1)
+		state.SetBPM(*bpm)
+		state.SetSmoothing(*smoothing)
+		if err := state.Bounce(*renderPath, *bars, *bits); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rendering mix: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sink, err := openSink(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	notes, err := mixer.ParseNoteMap(*noteMap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *programChannel < 0 || *programChannel > 16 {
+		fmt.Fprintf(os.Stderr, "Error: -program-channel must be 0-16, got %d\n", *programChannel)
+		os.Exit(1)
+	}
+	if err := midi.UseDriver(*midiDriver); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create initial state with 8 channels
-	state := mixer.NewState(8)
+	state := mixer.NewState(8, sink)
+	patternErr := state.LoadPatterns(*patternDir)
+	profileErr := state.LoadProfiles(*profileDir)
+	bindingsErr := state.LoadBindings(*bindings)
+	state.SetPattern(*pattern - 1)
+	state.SetBPM(*bpm)
+	state.SetSmoothing(*smoothing)
+	state.SetClockOutput(*clockOut)
+	state.SetFollow(*follow)
+	state.SetMCU(*mcu)
+	state.SetProgramChannel(*programChannel - 1)
+	state.SetNoteMap(notes)
+
+	var virtualErr error
+	if *virtual != "" {
+		virtualErr = connectVirtual(state, *virtual)
+	}
+	state.MidiHandler.WatchPorts(portsInterval)
 
 	// Create model
 	model := Model{
 		state:       state,
 		currentView: ViewMixer,
+		err:         errors.Join(state.AudioErr, patternErr, profileErr, bindingsErr, virtualErr),
 	}
 
 	// Run the program
@@ -0,0 +1,226 @@
+package midi
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// AllChannels is the channel filter of a device that accepts every channel
+const AllChannels = -1
+
+// Device describes a connected MIDI device, such as one controller: an
+// input, an output or both
+type Device struct {
+	ID      int
+	In      drivers.In  // Nil for an output-only device
+	Out     drivers.Out // Nil for an input-only device
+	Channel int         // MIDI channel accepted from In, 0-15, or AllChannels
+	MCU     bool        // Input and output speak Mackie Control
+}
+
+// Name names the device after its input, or its output if it has none
+func (d Device) Name() string {
+	if d.In != nil {
+		return d.In.String()
+	}
+	if d.Out != nil {
+		return d.Out.String()
+	}
+	return "None"
+}
+
+// device is a connected device and its listener. Settings the input
+// listener reads are atomic, as it runs on the driver's thread.
+type device struct {
+	id      int
+	in      drivers.In
+	out     drivers.Out
+	stop    func()
+	channel atomic.Int32
+	mcu     atomic.Bool
+	params  [16]parameter // Selected NRPN of each MIDI channel, used by the listener only
+	banks   [16]bank      // Bank Select of each MIDI channel, used by the listener only
+}
+
+// accepts reports whether the device's channel filter lets a channel through
+func (d *device) accepts(channel uint8) bool {
+	filter := d.channel.Load()
+	return filter == AllChannels || filter == int32(channel)
+}
+
+// info returns the device's description
+func (d *device) info() Device {
+	return Device{ID: d.id, In: d.in, Out: d.out, Channel: int(d.channel.Load()), MCU: d.mcu.Load()}
+}
+
+// close stops listening and closes the output. Virtual ports live on until
+// CloseVirtualPorts.
+func (d *device) close() {
+	if d.stop != nil {
+		d.stop()
+	}
+	if d.out != nil && !IsVirtual(d.out) {
+		d.out.Close()
+	}
+}
+
+// samePort reports whether two ports are the same, as port values are
+// created anew each time the driver lists them
+func samePort(a, b drivers.Port) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
+
+// AddDevice connects an input and output port, either of which may be nil,
+// as a new device accepting all channels, and returns its ID. Devices using
+// either port already are disconnected first, and reconnected if the new
+// ports fail to open, so a failed attempt keeps the working connection.
+func (h *Handler) AddDevice(inPort drivers.In, outPort drivers.Out) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var kept, replaced []*device
+	for _, d := range h.devices {
+		if samePort(d.in, inPort) || samePort(d.out, outPort) {
+			d.close()
+			replaced = append(replaced, d)
+		} else {
+			kept = append(kept, d)
+		}
+	}
+
+	h.nextID++
+	d := &device{id: h.nextID, in: inPort, out: outPort}
+	d.channel.Store(AllChannels)
+	d.mcu.Store(h.mcu.Load())
+
+	if err := h.open(d); err != nil {
+		for _, old := range replaced {
+			if h.open(old) == nil {
+				kept = append(kept, old)
+			}
+		}
+		h.devices = kept
+		return 0, err
+	}
+
+	h.devices = append(kept, d)
+	return d.id, nil
+}
+
+// open opens a device's output port and starts listening on its input
+func (h *Handler) open(d *device) error {
+	if d.out != nil {
+		if err := d.out.Open(); err != nil {
+			return fmt.Errorf("failed to open output port: %w", err)
+		}
+	}
+
+	if d.in != nil {
+		stop, err := midi.ListenTo(d.in, func(msg midi.Message, timestampms int32) {
+			h.handleMIDI(d, msg, timestampms)
+		}, midi.UseSysEx(), midi.UseTimeCode())
+		if err != nil {
+			if d.out != nil && !IsVirtual(d.out) {
+				d.out.Close()
+			}
+			return fmt.Errorf("failed to listen on input port: %w", err)
+		}
+		d.stop = stop
+	}
+	return nil
+}
+
+// RemoveDevice disconnects a device
+func (h *Handler) RemoveDevice(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, d := range h.devices {
+		if d.id == id {
+			d.close()
+			h.devices = append(h.devices[:i], h.devices[i+1:]...)
+			return
+		}
+	}
+}
+
+// Devices returns the connected devices in the order they were connected
+func (h *Handler) Devices() []Device {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	devices := make([]Device, len(h.devices))
+	for i, d := range h.devices {
+		devices[i] = d.info()
+	}
+	return devices
+}
+
+// SetDeviceChannel sets the MIDI channel, 0-15, accepted from a device's
+// input, or AllChannels
+func (h *Handler) SetDeviceChannel(id, channel int) {
+	if d := h.device(id); d != nil {
+		d.channel.Store(int32(channel))
+	}
+}
+
+// SetDeviceMCU switches a device between plain MIDI and an MCU surface
+func (h *Handler) SetDeviceMCU(id int, on bool) {
+	if d := h.device(id); d != nil {
+		d.mcu.Store(on)
+	}
+}
+
+// device returns a connected device by ID, or nil
+func (h *Handler) device(id int) *device {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, d := range h.devices {
+		if d.id == id {
+			return d
+		}
+	}
+	return nil
+}
+
+// IsConnected returns whether any MIDI device is connected
+func (h *Handler) IsConnected() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.devices) > 0
+}
+
+// send writes a message to every device's output
+func (h *Handler) send(msg midi.Message) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var firstErr error
+	for _, d := range h.devices {
+		if d.out == nil {
+			continue // No output port, silently ignore
+		}
+		h.recordOut(d, msg)
+		if err := d.out.Send(msg); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// sendTo writes a message to one device's output
+func (h *Handler) sendTo(id int, msg midi.Message) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, d := range h.devices {
+		if d.id == id && d.out != nil {
+			h.recordOut(d, msg)
+			return d.out.Send(msg)
+		}
+	}
+	return nil // No output port, silently ignore
+}
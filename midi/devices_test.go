@@ -0,0 +1,49 @@
+package midi
+
+import (
+	"errors"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+
+	"midi-mixer/midi/loopback"
+)
+
+// brokenOut is an output port that is listed but fails to open
+type brokenOut struct {
+	*loopback.Out
+}
+
+func (brokenOut) Open() error { return errors.New("device busy") }
+
+func TestAddDeviceKeepsConnectionOnFailure(t *testing.T) {
+	h, drv, id := connectLoopback(t)
+
+	// Reconnecting to the same ports fails, leaving the old connection up
+	if _, err := h.AddDevice(drv.In, brokenOut{loopback.New("Test").Out}); err == nil {
+		t.Fatal("AddDevice succeeded with a port that can't open")
+	}
+	devices := h.Devices()
+	if len(devices) != 1 || devices[0].ID != id {
+		t.Fatalf("devices %v after a failed reconnect, want device %d kept", devices, id)
+	}
+	if err := h.SendCC(id, 0, 7, 100); err != nil {
+		t.Errorf("sending to the kept device: %v", err)
+	}
+	if sent := drv.Out.Sent(); len(sent) != 1 {
+		t.Errorf("kept device was sent %v, want the CC", sent)
+	}
+	inject(t, drv, midi.ControlChange(0, 7, 64))
+	if got := h.NextCC(); len(got) != 1 || got[0].Device != id {
+		t.Errorf("took %+v, want a CC from device %d", got, id)
+	}
+
+	// A successful reconnect replaces it
+	newID, err := h.AddDevice(drv.In, drv.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devices := h.Devices(); len(devices) != 1 || devices[0].ID != newID {
+		t.Errorf("devices %v, want only device %d", devices, newID)
+	}
+}
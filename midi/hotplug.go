@@ -0,0 +1,83 @@
+package midi
+
+import (
+	"slices"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// PortsMessage lists the MIDI ports available after ports appeared or
+// disappeared, such as a USB controller being plugged in or dropping out
+type PortsMessage struct {
+	Ins  []drivers.In
+	Outs []drivers.Out
+}
+
+// In returns the available input port named like port, or nil
+func (m PortsMessage) In(port drivers.Port) drivers.In {
+	for _, in := range m.Ins {
+		if samePort(in, port) {
+			return in
+		}
+	}
+	return nil
+}
+
+// Out returns the available output port named like port, or nil
+func (m PortsMessage) Out(port drivers.Port) drivers.Out {
+	for _, out := range m.Outs {
+		if samePort(out, port) {
+			return out
+		}
+	}
+	return nil
+}
+
+// WatchPorts lists the MIDI ports every interval in the background and
+// sends a PortsMessage on PortsChannel whenever they change, until Close
+func (h *Handler) WatchPorts(interval time.Duration) {
+	names := portNames(GetInputPorts(), GetOutputPorts())
+	h.watch.Add(1)
+	go func() {
+		defer h.watch.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.done:
+				return
+			case <-ticker.C:
+			}
+
+			ins, outs := GetInputPorts(), GetOutputPorts()
+			if latest := portNames(ins, outs); !slices.Equal(latest, names) {
+				names = latest
+				select {
+				case h.portsChan <- PortsMessage{Ins: ins, Outs: outs}:
+				case <-h.done:
+					return
+				}
+			}
+		}
+	}()
+}
+
+// portNames lists the names of the given ports, inputs first
+func portNames(ins []drivers.In, outs []drivers.Out) []string {
+	names := make([]string, 0, len(ins)+len(outs)+1)
+	for _, in := range ins {
+		names = append(names, in.String())
+	}
+	names = append(names, "") // Separates inputs from outputs
+	for _, out := range outs {
+		names = append(names, out.String())
+	}
+	return names
+}
+
+// PortsChannel returns the channel for receiving port changes
+func (h *Handler) PortsChannel() <-chan PortsMessage {
+	return h.portsChan
+}
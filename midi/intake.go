@@ -0,0 +1,172 @@
+package midi
+
+import "sync"
+
+// ccQueueSize is the number of CC messages that can wait for the UI. Fader
+// moves are coalesced, so it only fills when the UI stalls while many
+// different controls move or buttons are hammered.
+const ccQueueSize = 1024
+
+// ccKey identifies a control on a device: a CC, or an NRPN parameter
+type ccKey struct {
+	device              int
+	channel, controller uint8
+	nrpn                bool
+	parameter           uint16
+}
+
+// ccQueue holds incoming CC messages until the UI takes them a batch at a
+// time. A control's new value replaces the one still waiting, latest value
+// wins, so a fast fader sweep can't leave the UI behind. Presses and
+// releases aren't merged, as buttons act when their value turns nonzero.
+type ccQueue struct {
+	mu      sync.Mutex
+	pending []CCMessage
+	latest  map[ccKey]int // Index in pending of each control's last message
+	ready   chan struct{} // Signalled when messages are waiting
+	closed  bool
+}
+
+// newCCQueue creates an empty CC queue
+func newCCQueue() *ccQueue {
+	return &ccQueue{
+		latest: make(map[ccKey]int),
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push queues a message, reporting false if it had to be dropped
+func (q *ccQueue) push(msg CCMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true
+	}
+
+	key := ccKey{msg.Device, msg.Channel, msg.Controller, msg.NRPN, msg.Parameter}
+	if i, ok := q.latest[key]; ok && q.pending[i].pressed() == msg.pressed() {
+		q.pending[i] = msg
+		return true
+	}
+	if len(q.pending) >= ccQueueSize {
+		return false
+	}
+	q.latest[key] = len(q.pending)
+	q.pending = append(q.pending, msg)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+		// Already signalled
+	}
+	return true
+}
+
+// take waits for messages and returns all of them, oldest first, or nil
+// once the queue is closed
+func (q *ccQueue) take() []CCMessage {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			batch := q.pending
+			q.pending = nil
+			clear(q.latest)
+			q.mu.Unlock()
+			return batch
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return nil
+		}
+		q.mu.Unlock()
+		<-q.ready
+	}
+}
+
+// close wakes any waiting take for good
+func (q *ccQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ready)
+	}
+}
+
+// parameter is the NRPN a MIDI channel's data entry sets, if any
+type parameter struct {
+	nrpn   bool   // An NRPN is selected, rather than an RPN or nothing
+	number uint16 // Parameter number, built from its MSB and LSB
+	msb    uint8  // Data entry MSB last received
+}
+
+// dataEntry decodes the CCs selecting and setting NRPNs on a device. It
+// returns an NRPN message for data entry to a selected NRPN, or the CC
+// itself, and reports whether the CC was used up selecting a parameter.
+// RPNs aren't mixer controls, so their data entry passes as plain CCs.
+func (d *device) dataEntry(channel, cc, value uint8) (CCMessage, bool) {
+	msg := CCMessage{Device: d.id, Channel: channel, Controller: cc, Value: value}
+	p := &d.params[channel]
+	switch {
+	case cc == CCNRPNMSB:
+		p.number = uint16(value)<<7 | p.number&0x7F
+		p.nrpn = p.number != nullParameter14
+		return msg, true
+	case cc == CCNRPNLSB:
+		p.number = p.number&^0x7F | uint16(value)
+		p.nrpn = p.number != nullParameter14
+		return msg, true
+	case cc == CCRPNMSB || cc == CCRPNLSB:
+		p.nrpn = false
+	case cc == CCDataEntry && p.nrpn:
+		// The MSB starts a new value; an LSB may follow
+		p.msb = value
+		return CCMessage{Device: d.id, Channel: channel, Value: value, NRPN: true,
+			Parameter: p.number, Value14: uint16(value) << 7}, false
+	case cc == CCDataEntryLSB && p.nrpn:
+		return CCMessage{Device: d.id, Channel: channel, Value: p.msb, NRPN: true,
+			Parameter: p.number, Value14: uint16(p.msb)<<7 | uint16(value)}, false
+	}
+	return msg, false
+}
+
+// bank is the Bank Select a MIDI channel last received. Many devices send
+// only the MSB, so it is the bank on its own until an LSB follows it.
+type bank struct {
+	msb, lsb uint8
+	hasLSB   bool // An LSB arrived since the MSB
+}
+
+// number returns the bank: the MSB alone, or MSB and LSB as 14 bits
+func (b bank) number() uint16 {
+	if !b.hasLSB {
+		return uint16(b.msb)
+	}
+	return uint16(b.msb)<<7 | uint16(b.lsb)
+}
+
+// bankSelect records the bank a Bank Select CC chooses for the channel's
+// next Program Change. A new MSB drops the LSB sent before it. The CC is
+// still passed on like any other.
+func (d *device) bankSelect(channel, cc, value uint8) {
+	b := &d.banks[channel]
+	switch cc {
+	case CCBankSelect:
+		*b = bank{msb: value}
+	case CCBankSelectLSB:
+		b.lsb, b.hasLSB = value, true
+	}
+}
+
+// NextCC waits for incoming CC messages and returns those that arrived
+// since the last call, with fader moves coalesced. It returns nil once the
+// handler is closed.
+func (h *Handler) NextCC() []CCMessage {
+	return h.ccQueue.take()
+}
+
+// Dropped returns how many incoming messages have been dropped because the
+// mixer couldn't keep up
+func (h *Handler) Dropped() uint64 {
+	return h.dropped.Load()
+}
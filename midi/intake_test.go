@@ -0,0 +1,135 @@
+package midi
+
+import (
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+
+	"midi-mixer/midi/loopback"
+)
+
+// connectLoopback returns a handler with a loopback controller connected
+func connectLoopback(t *testing.T) (*Handler, *loopback.Driver, int) {
+	t.Helper()
+	h := NewHandler()
+	t.Cleanup(h.Close)
+	drv := loopback.New("Test")
+	id, err := h.AddDevice(drv.In, drv.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, drv, id
+}
+
+// inject sends messages into the loopback input as the controller would
+func inject(t *testing.T, drv *loopback.Driver, msgs ...midi.Message) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := drv.In.Inject(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCCQueueCoalesces(t *testing.T) {
+	q := newCCQueue()
+	for value := uint8(1); value <= 100; value++ {
+		q.push(CCMessage{Channel: 0, Controller: 7, Value: value})
+	}
+	q.push(CCMessage{Channel: 0, Controller: 10, Value: 64})
+	q.push(CCMessage{Channel: 1, Controller: 7, Value: 5})
+	q.push(CCMessage{Channel: 0, Controller: 7, Value: 127})
+
+	// Latest value wins, in the order controls first moved
+	want := []CCMessage{
+		{Channel: 0, Controller: 7, Value: 127},
+		{Channel: 0, Controller: 10, Value: 64},
+		{Channel: 1, Controller: 7, Value: 5},
+	}
+	got := q.take()
+	if len(got) != len(want) {
+		t.Fatalf("took %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCCQueueKeepsPresses(t *testing.T) {
+	// A quick press and release both reach the UI
+	q := newCCQueue()
+	q.push(CCMessage{Controller: 20, Value: 127})
+	q.push(CCMessage{Controller: 20, Value: 0})
+	q.push(CCMessage{Controller: 20, Value: 0})
+	if got := q.take(); len(got) != 2 || got[0].Value != 127 || got[1].Value != 0 {
+		t.Errorf("took %+v, want the press and one release", got)
+	}
+}
+
+func TestDroppedWhenFull(t *testing.T) {
+	h, drv, _ := connectLoopback(t)
+
+	// Every controller on every channel is a distinct control, more than fit
+	sent := 0
+	for ch := uint8(0); ch < 16 && sent < ccQueueSize+10; ch++ {
+		for cc := uint8(0); cc < 128 && sent < ccQueueSize+10; cc++ {
+			if cc == CCNRPNMSB || cc == CCNRPNLSB || cc == CCRPNMSB || cc == CCRPNLSB {
+				continue
+			}
+			inject(t, drv, midi.ControlChange(ch, cc, 1))
+			sent++
+		}
+	}
+	if got := h.Dropped(); got != 10 {
+		t.Errorf("Dropped = %d, want 10", got)
+	}
+
+	// Controls already waiting still take new values when full
+	inject(t, drv, midi.ControlChange(0, 0, 99))
+	if got := h.Dropped(); got != 10 {
+		t.Errorf("Dropped = %d after moving a waiting control, want 10", got)
+	}
+	batch := h.NextCC()
+	if len(batch) != ccQueueSize || batch[0].Value != 99 {
+		t.Errorf("took %d messages starting %+v, want %d starting with value 99", len(batch), batch[0], ccQueueSize)
+	}
+}
+
+func TestNRPNDataEntry(t *testing.T) {
+	h, drv, id := connectLoopback(t)
+
+	// Select NRPN 1/2 = 130, then set it to 64/5 by data entry MSB and LSB
+	inject(t, drv,
+		midi.ControlChange(3, CCNRPNMSB, 1),
+		midi.ControlChange(3, CCNRPNLSB, 2),
+		midi.ControlChange(3, CCDataEntry, 64),
+		midi.ControlChange(3, CCDataEntryLSB, 5),
+	)
+	want := CCMessage{Device: id, Channel: 3, Value: 64, NRPN: true, Parameter: 130, Value14: 64<<7 | 5}
+	if got := h.NextCC(); len(got) != 1 || got[0] != want {
+		t.Errorf("took %+v, want %+v", got, []CCMessage{want})
+	}
+
+	// The parameter stays selected for the next value, MSB alone
+	inject(t, drv, midi.ControlChange(3, CCDataEntry, 10))
+	want = CCMessage{Device: id, Channel: 3, Value: 10, NRPN: true, Parameter: 130, Value14: 10 << 7}
+	if got := h.NextCC(); len(got) != 1 || got[0] != want {
+		t.Errorf("took %+v, want %+v", got, []CCMessage{want})
+	}
+
+	// Data entry to an RPN, or with the parameter deselected, is a plain CC.
+	// RPN selection isn't a mixer control either, so it passes on too.
+	for _, selectMsgs := range [][]midi.Message{
+		{midi.ControlChange(3, CCRPNMSB, 0), midi.ControlChange(3, CCRPNLSB, 0)},
+		{midi.ControlChange(3, CCNRPNMSB, 127), midi.ControlChange(3, CCNRPNLSB, 127)},
+	} {
+		inject(t, drv, selectMsgs...)
+		inject(t, drv, midi.ControlChange(3, CCDataEntry, 20))
+		want = CCMessage{Device: id, Channel: 3, Controller: CCDataEntry, Value: 20}
+		if got := h.NextCC(); len(got) == 0 || got[len(got)-1] != want {
+			t.Errorf("took %+v, want it to end with %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package loopback provides an in-process MIDI driver with no hardware or
+// system MIDI behind it. Messages are injected into its input port as if a
+// controller sent them, and whatever is sent to its output port is kept for
+// reading back, so the MIDI path can run in tests and on headless machines
+// with no MIDI system. Go code selects it with midi.SetDriver, the mixer
+// with -midi-driver loopback.
+package loopback
+
+import (
+	"sync"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// Driver is a loopback MIDI driver with one input and one output port
+type Driver struct {
+	name string
+	In   *In
+	Out  *Out
+}
+
+// New creates a loopback driver whose ports are named after it
+func New(name string) *Driver {
+	return &Driver{
+		name: name,
+		In:   &In{name: name + " In"},
+		Out:  &Out{name: name + " Out"},
+	}
+}
+
+func (d *Driver) String() string               { return d.name }
+func (d *Driver) Close() error                 { d.In.Close(); return d.Out.Close() }
+func (d *Driver) Ins() ([]drivers.In, error)   { return []drivers.In{d.In}, nil }
+func (d *Driver) Outs() ([]drivers.Out, error) { return []drivers.Out{d.Out}, nil }
+
+// In is the loopback input port. Unlike hardware ports it may be used from
+// several goroutines.
+type In struct {
+	name   string
+	mu     sync.Mutex
+	open   bool
+	listen func(msg []byte, milliseconds int32)
+	config drivers.ListenConfig
+}
+
+func (p *In) String() string          { return p.name }
+func (p *In) Number() int             { return 0 }
+func (p *In) Underlying() interface{} { return nil }
+
+// Open opens the port
+func (p *In) Open() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = true
+	return nil
+}
+
+// Close closes the port and stops listening
+func (p *In) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = false
+	p.listen = nil
+	return nil
+}
+
+// IsOpen reports whether the port is open
+func (p *In) IsOpen() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open
+}
+
+// Listen passes injected messages to onMsg until the returned function is
+// called
+func (p *In) Listen(onMsg func(msg []byte, milliseconds int32), config drivers.ListenConfig) (func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.open {
+		return nil, drivers.ErrPortClosed
+	}
+	p.listen = onMsg
+	p.config = config
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.listen = nil
+	}, nil
+}
+
+// Inject delivers a message to the listener as if it arrived on the port,
+// returning once it has been handled. Messages the listener didn't ask for,
+// such as clock without time code, are dropped as real drivers do.
+func (p *In) Inject(msg midi.Message) error {
+	p.mu.Lock()
+	listen, config := p.listen, p.config
+	p.mu.Unlock()
+
+	switch {
+	case listen == nil:
+		return drivers.ErrListenStopped
+	case msg.Is(midi.ActiveSenseMsg) && !config.ActiveSense,
+		msg.Is(midi.TimingClockMsg) && !config.TimeCode,
+		msg.Is(midi.SysExMsg) && !config.SysEx:
+		return nil
+	}
+	listen(msg, 0)
+	return nil
+}
+
+// MaxSent is how many sent messages an output port keeps until read, so a
+// long headless run sending clock doesn't grow without bound
+const MaxSent = 65536
+
+// Out is the loopback output port, recording what is sent to it. It may be
+// used from several goroutines.
+type Out struct {
+	name string
+	mu   sync.Mutex
+	open bool
+	sent []midi.Message
+}
+
+func (p *Out) String() string          { return p.name }
+func (p *Out) Number() int             { return 0 }
+func (p *Out) Underlying() interface{} { return nil }
+
+// Open opens the port
+func (p *Out) Open() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = true
+	return nil
+}
+
+// Close closes the port
+func (p *Out) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open = false
+	return nil
+}
+
+// IsOpen reports whether the port is open
+func (p *Out) IsOpen() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open
+}
+
+// Send records a message. Past MaxSent unread messages the oldest are
+// forgotten.
+func (p *Out) Send(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.open {
+		return drivers.ErrPortClosed
+	}
+	if len(p.sent) >= MaxSent {
+		p.sent = append(p.sent[:0], p.sent[len(p.sent)-MaxSent/2:]...)
+	}
+	p.sent = append(p.sent, append(midi.Message(nil), data...))
+	return nil
+}
+
+// Sent returns the messages sent so far and forgets them, so each call
+// returns what was sent since the last
+func (p *Out) Sent() []midi.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sent := p.sent
+	p.sent = nil
+	return sent
+}
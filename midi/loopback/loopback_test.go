@@ -0,0 +1,26 @@
+package loopback
+
+import (
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestSentIsBounded(t *testing.T) {
+	d := New("Test")
+	if err := d.Out.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 * MaxSent {
+		if err := d.Out.Send(midi.ControlChange(0, 7, uint8(i%128))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := d.Out.Sent()
+	if len(sent) > MaxSent {
+		t.Errorf("kept %d messages, want at most %d", len(sent), MaxSent)
+	}
+	if last := sent[len(sent)-1]; string(last) != string(midi.ControlChange(0, 7, uint8((3*MaxSent-1)%128))) {
+		t.Errorf("last message kept is % X, want the last one sent", last)
+	}
+}
@@ -0,0 +1,163 @@
+package midi
+
+import (
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// Mackie Control Universal (MCU) surfaces send and receive fixed messages:
+// faders are 14-bit pitch bends on MIDI channels 1-9, buttons and fader
+// touch are notes on channel 1, V-Pot encoders are relative CCs, and the
+// scribble-strip LCD is written with SysEx.
+
+// MCUStrips is the number of channel strips on an MCU surface. The master
+// fader is strip MCUStrips.
+const MCUStrips = 8
+
+// MCU button notes. Strip buttons are the first note plus the strip.
+const (
+	MCURec          uint8 = 0
+	MCUSolo         uint8 = 8
+	MCUMute         uint8 = 16
+	MCUSelect       uint8 = 24
+	MCUVPotPush     uint8 = 32
+	MCUBankLeft     uint8 = 46
+	MCUBankRight    uint8 = 47
+	MCUChannelLeft  uint8 = 48
+	MCUChannelRight uint8 = 49
+	MCURewind       uint8 = 91
+	MCUForward      uint8 = 92
+	MCUStop         uint8 = 93
+	MCUPlay         uint8 = 94
+	MCURecord       uint8 = 95
+	MCUFaderTouch   uint8 = 104 // Up to 112 for the master fader
+)
+
+// MCU V-Pot CCs, and the CCs of their LED rings
+const (
+	mcuVPot     uint8 = 16
+	mcuVPotRing uint8 = 48
+)
+
+// MCU V-Pot LED ring modes
+const (
+	MCURingDot      uint8 = 0 << 4
+	MCURingBoostCut uint8 = 1 << 4
+	MCURingWrap     uint8 = 2 << 4
+	MCURingSpread   uint8 = 3 << 4
+)
+
+// MCUFaderMax is the top of an MCU fader's 14-bit range
+const MCUFaderMax = 16383
+
+// MCULCDWidth is the number of characters per LCD row, 7 per strip
+const MCULCDWidth = 7 * MCUStrips
+
+// mcuLCDHeader starts a Logic Control LCD SysEx, without the leading F0
+var mcuLCDHeader = []byte{0x00, 0x00, 0x66, 0x14, 0x12}
+
+// MCUKind identifies an input from an MCU surface
+type MCUKind int
+
+const (
+	MCUFader  MCUKind = iota // Fader moved, Value 0-MCUFaderMax
+	MCUTouch                 // Fader touched (Value 1) or let go (0)
+	MCUVPot                  // V-Pot turned by Value detents, negative to the left
+	MCUButton                // Button pressed (Value 1) or released (0)
+)
+
+// MCUMessage is an input from an MCU surface
+type MCUMessage struct {
+	Device int // ID of the device it came from
+	Kind   MCUKind
+	Strip  int   // Strip of a fader, touch or V-Pot; MCUStrips for the master fader
+	Button uint8 // Note number of a button
+	Value  int
+}
+
+// decodeMCU translates a message from an MCU surface
+func decodeMCU(msg midi.Message) (MCUMessage, bool) {
+	var ch, key, cc, val uint8
+	var fader uint16
+	switch {
+	case msg.GetPitchBend(&ch, nil, &fader):
+		if int(ch) > MCUStrips {
+			return MCUMessage{}, false
+		}
+		return MCUMessage{Kind: MCUFader, Strip: int(ch), Value: int(fader)}, true
+	case msg.GetNoteStart(&ch, &key, &val):
+		return mcuButton(key, 1), true
+	case msg.GetNoteEnd(&ch, &key):
+		return mcuButton(key, 0), true
+	case msg.GetControlChange(&ch, &cc, &val):
+		if cc < mcuVPot || cc >= mcuVPot+MCUStrips {
+			return MCUMessage{}, false
+		}
+		// Bit 6 is the direction, the rest the number of detents
+		delta := int(val & 0x3f)
+		if val&0x40 != 0 {
+			delta = -delta
+		}
+		return MCUMessage{Kind: MCUVPot, Strip: int(cc - mcuVPot), Value: delta}, true
+	}
+	return MCUMessage{}, false
+}
+
+// mcuButton translates a button or touch note
+func mcuButton(key uint8, value int) MCUMessage {
+	if key >= MCUFaderTouch && key <= MCUFaderTouch+MCUStrips {
+		return MCUMessage{Kind: MCUTouch, Strip: int(key - MCUFaderTouch), Value: value}
+	}
+	return MCUMessage{Kind: MCUButton, Button: key, Value: value}
+}
+
+// SetMCU sets whether devices connected from now on are MCU surfaces. The
+// notes and CCs of an MCU device arrive on the MCU channel instead of the
+// note and CC channels; clock messages are unaffected.
+func (h *Handler) SetMCU(on bool) {
+	h.mcu.Store(on)
+}
+
+// MCU reports whether new devices are MCU surfaces
+func (h *Handler) MCU() bool {
+	return h.mcu.Load()
+}
+
+// MCUChannel returns the channel for receiving MCU surface input
+func (h *Handler) MCUChannel() <-chan MCUMessage {
+	return h.mcuChan
+}
+
+// SendMCUFader moves a motorized fader to a 14-bit position
+func (h *Handler) SendMCUFader(device, strip, value int) error {
+	return h.sendTo(device, midi.Pitchbend(uint8(strip), int16(value-(MCUFaderMax+1)/2)))
+}
+
+// SendMCULED lights or clears a button LED
+func (h *Handler) SendMCULED(device int, button uint8, on bool) error {
+	var velocity uint8
+	if on {
+		velocity = 127
+	}
+	return h.sendTo(device, midi.NoteOn(0, button, velocity))
+}
+
+// SendMCURing sets a V-Pot LED ring to a mode and a position of 0-11, 0
+// being all off
+func (h *Handler) SendMCURing(device, strip int, mode, position uint8) error {
+	return h.sendTo(device, midi.ControlChange(0, mcuVPotRing+uint8(strip), mode|position))
+}
+
+// SendMCUText writes text to the LCD starting at a character offset; the top
+// row starts at 0 and the bottom row at MCULCDWidth. Characters outside
+// printable ASCII are shown as spaces.
+func (h *Handler) SendMCUText(device, offset int, text string) error {
+	data := append([]byte(nil), mcuLCDHeader...)
+	data = append(data, byte(offset))
+	for _, r := range text {
+		if r < 0x20 || r > 0x7e {
+			r = ' '
+		}
+		data = append(data, byte(r))
+	}
+	return h.sendTo(device, midi.SysEx(data))
+}
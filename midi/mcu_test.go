@@ -0,0 +1,48 @@
+package midi
+
+import (
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestDecodeMCU(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  midi.Message
+		want MCUMessage
+		ok   bool
+	}{
+		// Faders are pitch bends on the strip's channel, 0-MCUFaderMax
+		{"fader bottom", midi.Pitchbend(0, -8192), MCUMessage{Kind: MCUFader, Strip: 0, Value: 0}, true},
+		{"fader center", midi.Pitchbend(3, 0), MCUMessage{Kind: MCUFader, Strip: 3, Value: 8192}, true},
+		{"fader top", midi.Pitchbend(7, 8191), MCUMessage{Kind: MCUFader, Strip: 7, Value: MCUFaderMax}, true},
+		{"master fader", midi.Pitchbend(8, 8191), MCUMessage{Kind: MCUFader, Strip: MCUStrips, Value: MCUFaderMax}, true},
+		{"bend past the master", midi.Pitchbend(9, 0), MCUMessage{}, false},
+
+		// V-Pots send detents with bit 6 turning them left
+		{"vpot right", midi.ControlChange(0, 16, 1), MCUMessage{Kind: MCUVPot, Strip: 0, Value: 1}, true},
+		{"vpot fast right", midi.ControlChange(0, 21, 5), MCUMessage{Kind: MCUVPot, Strip: 5, Value: 5}, true},
+		{"vpot left", midi.ControlChange(0, 23, 0x41), MCUMessage{Kind: MCUVPot, Strip: 7, Value: -1}, true},
+		{"vpot fast left", midi.ControlChange(0, 16, 0x44), MCUMessage{Kind: MCUVPot, Strip: 0, Value: -4}, true},
+		{"cc below the vpots", midi.ControlChange(0, 15, 1), MCUMessage{}, false},
+		{"cc past the vpots", midi.ControlChange(0, 24, 1), MCUMessage{}, false},
+
+		// Touch is a note per fader, the master's included
+		{"touch", midi.NoteOn(0, 104, 127), MCUMessage{Kind: MCUTouch, Strip: 0, Value: 1}, true},
+		{"release", midi.NoteOn(0, 106, 0), MCUMessage{Kind: MCUTouch, Strip: 2, Value: 0}, true},
+		{"master touch", midi.NoteOn(0, 112, 127), MCUMessage{Kind: MCUTouch, Strip: MCUStrips, Value: 1}, true},
+
+		{"button", midi.NoteOn(0, MCUPlay, 127), MCUMessage{Kind: MCUButton, Button: MCUPlay, Value: 1}, true},
+		{"button up", midi.NoteOff(0, MCUMute+2), MCUMessage{Kind: MCUButton, Button: MCUMute + 2, Value: 0}, true},
+		{"program change", midi.ProgramChange(0, 1), MCUMessage{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeMCU(tt.msg)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("decodeMCU(%v) = %+v, %v, want %+v, %v", tt.msg, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -3,17 +3,69 @@ package midi
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/drivers"
-	_ "gitlab.com/gomidi/midi/v2/drivers/rtmididrv"
+
+	"midi-mixer/midi/loopback"
 )
 
-// CCMessage represents a MIDI Control Change message
+// CCMessage represents a MIDI Control Change message, or an NRPN data
+// entry: the value of a 14-bit parameter number selected by earlier CCs
 type CCMessage struct {
+	Device     int // ID of the device it came from
 	Channel    uint8
-	Controller uint8
-	Value      uint8
+	Controller uint8 // CC number, 0 for NRPN
+	Value      uint8 // 0-127, the data entry MSB for NRPN
+	NRPN       bool
+	Parameter  uint16 // NRPN parameter number, 0-16383
+	Value14    uint16 // NRPN value, 0-16383
+}
+
+// pressed reports whether the message holds a button down
+func (m CCMessage) pressed() bool {
+	if m.NRPN {
+		return m.Value14 > 0
+	}
+	return m.Value > 0
+}
+
+// NoteMessage represents a MIDI Note On or Note Off message. A Note On with
+// velocity 0 is delivered as a Note Off.
+type NoteMessage struct {
+	Device   int // ID of the device it came from
+	Channel  uint8
+	Key      uint8
+	Velocity uint8 // 0 for Note Off
+}
+
+// ProgramMessage represents a MIDI Program Change, with the bank last
+// chosen by Bank Select on its channel
+type ProgramMessage struct {
+	Device  int // ID of the device it came from
+	Channel uint8
+	Bank    uint16 // 0-16383, from Bank Select MSB and LSB, or 0-127 from an MSB alone
+	Program uint8
+}
+
+// ClockKind identifies an incoming MIDI clock or transport message
+type ClockKind int
+
+const (
+	ClockTick ClockKind = iota
+	ClockStart
+	ClockStop
+	ClockContinue
+	ClockSongPosition
+)
+
+// ClockMessage is a MIDI clock or transport message with its arrival time
+type ClockMessage struct {
+	Kind     ClockKind
+	Position uint16 // Song position in 16ths, for ClockSongPosition
+	Time     time.Time
 }
 
 // Common MIDI CC numbers for mixer controls
@@ -25,141 +77,321 @@ const (
 	CCChorus     uint8 = 93
 )
 
-// Handler manages MIDI input/output connections
+// CC numbers of 14-bit controls and parameter numbers. CCs 0-31 are the
+// MSB of a control whose LSB is 32 higher; NRPN and RPN select a parameter
+// whose value comes by data entry.
+const (
+	CCLSBOffset     uint8 = 32
+	CCBankSelect    uint8 = 0
+	CCBankSelectLSB uint8 = 32
+	CCDataEntry     uint8 = 6
+	CCDataEntryLSB  uint8 = 38
+	CCNRPNLSB       uint8 = 98
+	CCNRPNMSB       uint8 = 99
+	CCRPNLSB        uint8 = 100
+	CCRPNMSB        uint8 = 101
+	Max14                 = 16383 // Top of a 14-bit value
+	nullParameter14       = 16383 // NRPN/RPN number deselecting the parameter
+)
+
+// Handler manages MIDI input/output connections to any number of devices
 type Handler struct {
-	inPort    drivers.In
-	outPort   drivers.Out
-	stopFunc  func()
-	ccChan    chan CCMessage
+	devices   []*device
+	nextID    int
+	ccQueue   *ccQueue
+	noteChan  chan NoteMessage
+	progChan  chan ProgramMessage
+	clockChan chan ClockMessage
+	mcuChan   chan MCUMessage
+	portsChan chan PortsMessage
+	mcu       atomic.Bool // New devices are MCU surfaces
 	mu        sync.RWMutex
-	connected bool
+	done      chan struct{}  // Closed to stop the port watcher
+	watch     sync.WaitGroup // The port watcher, if running
+	monitor   monitorLog
+	dropped   atomic.Uint64 // Incoming messages dropped for falling behind
 }
 
 // NewHandler creates a new MIDI handler
 func NewHandler() *Handler {
 	return &Handler{
-		ccChan: make(chan CCMessage, 100),
+		ccQueue:   newCCQueue(),
+		noteChan:  make(chan NoteMessage, 256),
+		progChan:  make(chan ProgramMessage, 256),
+		clockChan: make(chan ClockMessage, 256),
+		mcuChan:   make(chan MCUMessage, 256),
+		portsChan: make(chan PortsMessage, 1),
+		done:      make(chan struct{}),
 	}
 }
 
-// GetInputPorts returns available MIDI input ports
-func GetInputPorts() []drivers.In {
-	return midi.GetInPorts()
+// driver lists the ports, nil for the first registered driver. The program
+// registers rtmidi; without it, as in tests, there are no system ports.
+var (
+	driverMu sync.RWMutex
+	driver   drivers.Driver
+)
+
+// SetDriver selects the MIDI driver that ports are listed from, such as a
+// loopback driver for tests and headless runs. Nil restores the default.
+func SetDriver(d drivers.Driver) {
+	driverMu.Lock()
+	defer driverMu.Unlock()
+	driver = d
 }
 
-// GetOutputPorts returns available MIDI output ports
-func GetOutputPorts() []drivers.Out {
-	return midi.GetOutPorts()
+// UseDriver selects a MIDI driver by name: "rtmidi" for the system's MIDI
+// ports, or "loopback" for in-process ports with no hardware behind them
+func UseDriver(name string) error {
+	switch name {
+	case "rtmidi":
+		SetDriver(nil)
+	case "loopback":
+		SetDriver(loopback.New("Loopback"))
+	default:
+		return fmt.Errorf("unknown MIDI driver %q (want rtmidi or loopback)", name)
+	}
+	return nil
 }
 
-// Connect opens the specified input and output ports
-func (h *Handler) Connect(inPort drivers.In, outPort drivers.Out) error {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// currentDriver returns the selected MIDI driver
+func currentDriver() drivers.Driver {
+	driverMu.RLock()
+	defer driverMu.RUnlock()
+	if driver != nil {
+		return driver
+	}
+	return drivers.Get()
+}
 
-	if h.connected {
-		h.disconnect()
+// GetInputPorts returns available MIDI input ports, starting with the
+// mixer's virtual input if it has one
+func GetInputPorts() []drivers.In {
+	var ins []drivers.In
+	driverMu.RLock()
+	if virtualIn != nil {
+		ins = append(ins, virtualIn)
 	}
+	driverMu.RUnlock()
 
-	h.inPort = inPort
-	h.outPort = outPort
+	if d := currentDriver(); d != nil {
+		ports, _ := d.Ins()
+		ins = append(ins, ports...)
+	}
+	return ins
+}
 
-	// Open output port if specified
-	if outPort != nil {
-		if err := outPort.Open(); err != nil {
-			return fmt.Errorf("failed to open output port: %w", err)
-		}
+// GetOutputPorts returns available MIDI output ports, starting with the
+// mixer's virtual output if it has one
+func GetOutputPorts() []drivers.Out {
+	var outs []drivers.Out
+	driverMu.RLock()
+	if virtualOut != nil {
+		outs = append(outs, virtualOut)
 	}
+	driverMu.RUnlock()
 
-	// Start listening on input port if specified
-	if inPort != nil {
-		stop, err := midi.ListenTo(inPort, h.handleMIDI, midi.UseSysEx())
-		if err != nil {
-			if outPort != nil {
-				outPort.Close()
-			}
-			return fmt.Errorf("failed to listen on input port: %w", err)
+	if d := currentDriver(); d != nil {
+		ports, _ := d.Outs()
+		outs = append(outs, ports...)
+	}
+	return outs
+}
+
+// handleMIDI processes incoming MIDI messages from a device
+func (h *Handler) handleMIDI(d *device, msg midi.Message, timestampms int32) {
+	h.recordIn(d, msg, timestampms)
+
+	var ch, cc, key, val uint8
+	var spp uint16
+	if d.mcu.Load() && msg.Type().Is(midi.ChannelMsg) {
+		if mcu, ok := decodeMCU(msg); ok {
+			mcu.Device = d.id
+			h.pushMCU(mcu)
 		}
-		h.stopFunc = stop
+		return
+	}
+	if msg.GetChannel(&ch) && !d.accepts(ch) {
+		return
 	}
 
-	h.connected = true
-	return nil
+	switch {
+	case msg.GetControlChange(&ch, &cc, &val):
+		d.bankSelect(ch, cc, val)
+		m, consumed := d.dataEntry(ch, cc, val)
+		if consumed {
+			break
+		}
+		if !h.ccQueue.push(m) {
+			h.dropped.Add(1)
+		}
+	case msg.GetProgramChange(&ch, &val):
+		h.pushProgram(ProgramMessage{Device: d.id, Channel: ch, Bank: d.banks[ch].number(), Program: val})
+	case msg.GetNoteStart(&ch, &key, &val):
+		h.pushNote(NoteMessage{Device: d.id, Channel: ch, Key: key, Velocity: val})
+	case msg.GetNoteEnd(&ch, &key):
+		h.pushNote(NoteMessage{Device: d.id, Channel: ch, Key: key})
+	case msg.Is(midi.TimingClockMsg):
+		h.pushClock(ClockMessage{Kind: ClockTick})
+	case msg.Is(midi.StartMsg):
+		h.pushClock(ClockMessage{Kind: ClockStart})
+	case msg.Is(midi.StopMsg):
+		h.pushClock(ClockMessage{Kind: ClockStop})
+	case msg.Is(midi.ContinueMsg):
+		h.pushClock(ClockMessage{Kind: ClockContinue})
+	case msg.GetSPP(&spp):
+		h.pushClock(ClockMessage{Kind: ClockSongPosition, Position: spp})
+	}
 }
 
-// handleMIDI processes incoming MIDI messages
-func (h *Handler) handleMIDI(msg midi.Message, timestampms int32) {
-	var ch, cc, val uint8
-	if msg.GetControlChange(&ch, &cc, &val) {
-		select {
-		case h.ccChan <- CCMessage{Channel: ch, Controller: cc, Value: val}:
-		default:
-			// Channel full, drop message
-		}
+// pushNote queues a note message
+func (h *Handler) pushNote(msg NoteMessage) {
+	select {
+	case h.noteChan <- msg:
+	default:
+		// Channel full, drop message
+		h.dropped.Add(1)
 	}
 }
 
-// CCChannel returns the channel for receiving CC messages
-func (h *Handler) CCChannel() <-chan CCMessage {
-	return h.ccChan
+// pushProgram queues a program change
+func (h *Handler) pushProgram(msg ProgramMessage) {
+	select {
+	case h.progChan <- msg:
+	default:
+		// Channel full, drop message
+		h.dropped.Add(1)
+	}
 }
 
-// SendCC sends a Control Change message
-func (h *Handler) SendCC(channel, controller, value uint8) error {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// pushMCU queues an MCU surface input
+func (h *Handler) pushMCU(msg MCUMessage) {
+	select {
+	case h.mcuChan <- msg:
+	default:
+		// Channel full, drop message
+		h.dropped.Add(1)
+	}
+}
 
-	if h.outPort == nil || !h.connected {
-		return nil // No output port, silently ignore
+// pushClock stamps a clock message with its arrival time and queues it
+func (h *Handler) pushClock(msg ClockMessage) {
+	msg.Time = time.Now()
+	select {
+	case h.clockChan <- msg:
+	default:
+		// Channel full, drop message
+		h.dropped.Add(1)
 	}
+}
+
+// NoteChannel returns the channel for receiving note messages
+func (h *Handler) NoteChannel() <-chan NoteMessage {
+	return h.noteChan
+}
 
-	msg := midi.ControlChange(channel, controller, value)
-	return h.outPort.Send(msg)
+// ProgramChannel returns the channel for receiving program changes
+func (h *Handler) ProgramChannel() <-chan ProgramMessage {
+	return h.progChan
 }
 
-// disconnect closes all ports (must be called with lock held)
-func (h *Handler) disconnect() {
-	if h.stopFunc != nil {
-		h.stopFunc()
-		h.stopFunc = nil
+// ClockChannel returns the channel for receiving clock and transport messages
+func (h *Handler) ClockChannel() <-chan ClockMessage {
+	return h.clockChan
+}
+
+// SendCC sends a Control Change message to a device
+func (h *Handler) SendCC(device int, channel, controller, value uint8) error {
+	return h.sendTo(device, midi.ControlChange(channel, controller, value))
+}
+
+// SendCC14 sends a 14-bit value, 0-16383, to a control whose MSB is the
+// given CC, 0-31, and whose LSB is 32 higher
+func (h *Handler) SendCC14(device int, channel, controller uint8, value uint16) error {
+	if err := h.sendTo(device, midi.ControlChange(channel, controller, uint8(value>>7))); err != nil {
+		return err
 	}
-	if h.outPort != nil {
-		h.outPort.Close()
+	return h.sendTo(device, midi.ControlChange(channel, controller+CCLSBOffset, uint8(value&0x7F)))
+}
+
+// SendNRPN sets an NRPN parameter, 0-16383, to a 14-bit value on a device
+func (h *Handler) SendNRPN(device int, channel uint8, parameter, value uint16) error {
+	for _, cc := range [][2]uint8{
+		{CCNRPNMSB, uint8(parameter >> 7)},
+		{CCNRPNLSB, uint8(parameter & 0x7F)},
+		{CCDataEntry, uint8(value >> 7)},
+		{CCDataEntryLSB, uint8(value & 0x7F)},
+	} {
+		if err := h.sendTo(device, midi.ControlChange(channel, cc[0], cc[1])); err != nil {
+			return err
+		}
 	}
-	h.connected = false
+	return nil
 }
 
-// Close closes all MIDI connections
-func (h *Handler) Close() {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.disconnect()
-	close(h.ccChan)
+// SendProgram sends a device a Program Change
+func (h *Handler) SendProgram(device int, channel, program uint8) error {
+	return h.sendTo(device, midi.ProgramChange(channel, program))
 }
 
-// IsConnected returns whether MIDI is connected
-func (h *Handler) IsConnected() bool {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return h.connected
+// SendBankSelect sends a device the bank, 0-16383, for the next Program
+// Change as Bank Select MSB and LSB
+func (h *Handler) SendBankSelect(device int, channel uint8, bank uint16) error {
+	if err := h.sendTo(device, midi.ControlChange(channel, CCBankSelect, uint8(bank>>7))); err != nil {
+		return err
+	}
+	return h.sendTo(device, midi.ControlChange(channel, CCBankSelectLSB, uint8(bank&0x7F)))
 }
 
-// GetInputPortName returns the name of the connected input port
-func (h *Handler) GetInputPortName() string {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	if h.inPort != nil {
-		return h.inPort.String()
+// SendNote sends a device a Note On, or a Note Off when velocity is 0.
+// Controllers light pad and button LEDs from notes.
+func (h *Handler) SendNote(device int, channel, key, velocity uint8) error {
+	if velocity == 0 {
+		return h.sendTo(device, midi.NoteOff(channel, key))
 	}
-	return "None"
+	return h.sendTo(device, midi.NoteOn(channel, key, velocity))
+}
+
+// SendClock sends every device a Timing Clock message (24 per quarter note)
+func (h *Handler) SendClock() error {
+	return h.send(midi.TimingClock())
+}
+
+// SendStart tells clock followers to start from the beginning
+func (h *Handler) SendStart() error {
+	return h.send(midi.Start())
+}
+
+// SendStop tells clock followers to stop
+func (h *Handler) SendStop() error {
+	return h.send(midi.Stop())
 }
 
-// GetOutputPortName returns the name of the connected output port
-func (h *Handler) GetOutputPortName() string {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	if h.outPort != nil {
-		return h.outPort.String()
+// SendContinue tells clock followers to resume from the current position
+func (h *Handler) SendContinue() error {
+	return h.send(midi.Continue())
+}
+
+// SendSongPosition sends a Song Position Pointer in 16th notes
+func (h *Handler) SendSongPosition(sixteenths uint16) error {
+	return h.send(midi.SPP(sixteenths))
+}
+
+// Close closes all MIDI connections
+func (h *Handler) Close() {
+	close(h.done)
+	h.watch.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, d := range h.devices {
+		d.close()
 	}
-	return "None"
+	h.devices = nil
+	h.ccQueue.close()
+	close(h.noteChan)
+	close(h.clockChan)
+	close(h.mcuChan)
+	close(h.progChan)
+	close(h.portsChan)
 }
@@ -0,0 +1,81 @@
+package midi
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+// MonitorSize is the number of messages the monitor log keeps
+const MonitorSize = 1000
+
+// MonitorEntry is a MIDI message received from or sent to a device
+type MonitorEntry struct {
+	Time      time.Time // When it was received or sent
+	Timestamp int32     // Driver timestamp in milliseconds, for received messages
+	Port      string    // Port it came in on or went out of
+	Out       bool      // Sent rather than received
+	Message   midi.Message
+}
+
+// monitorLog keeps the latest MonitorSize messages in a ring
+type monitorLog struct {
+	mu      sync.Mutex
+	entries []MonitorEntry
+	next    int         // Where the next entry goes once the ring is full
+	clock   atomic.Bool // Log timing clock and active sensing
+}
+
+// add logs a message, copying it as drivers may reuse its buffer. Timing
+// clock and active sensing are dropped unless logging them is on, as they
+// would push everything else out of the ring.
+func (l *monitorLog) add(e MonitorEntry) {
+	if !l.clock.Load() && e.Message.IsOneOf(midi.TimingClockMsg, midi.ActiveSenseMsg) {
+		return
+	}
+	e.Message = append(midi.Message(nil), e.Message...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < MonitorSize {
+		l.entries = append(l.entries, e)
+		return
+	}
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % MonitorSize
+}
+
+// recordIn logs a message received on a device's input
+func (h *Handler) recordIn(d *device, msg midi.Message, timestampms int32) {
+	h.monitor.add(MonitorEntry{Time: time.Now(), Timestamp: timestampms, Port: d.in.String(), Message: msg})
+}
+
+// recordOut logs a message sent to a device's output
+func (h *Handler) recordOut(d *device, msg midi.Message) {
+	h.monitor.add(MonitorEntry{Time: time.Now(), Port: d.out.String(), Out: true, Message: msg})
+}
+
+// Monitor returns the logged MIDI messages, oldest first
+func (h *Handler) Monitor() []MonitorEntry {
+	h.monitor.mu.Lock()
+	defer h.monitor.mu.Unlock()
+	entries := make([]MonitorEntry, 0, len(h.monitor.entries))
+	entries = append(entries, h.monitor.entries[h.monitor.next:]...)
+	return append(entries, h.monitor.entries[:h.monitor.next]...)
+}
+
+// SetMonitorClock sets whether the monitor logs timing clock and active
+// sensing from now on
+func (h *Handler) SetMonitorClock(on bool) {
+	h.monitor.clock.Store(on)
+}
+
+// ClearMonitor empties the monitor log
+func (h *Handler) ClearMonitor() {
+	h.monitor.mu.Lock()
+	defer h.monitor.mu.Unlock()
+	h.monitor.entries = nil
+	h.monitor.next = 0
+}
@@ -0,0 +1,30 @@
+package midi
+
+import (
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2"
+)
+
+func TestMonitorDropsClock(t *testing.T) {
+	var l monitorLog
+	log := func(msg midi.Message) { l.add(MonitorEntry{Message: msg}) }
+
+	// A stream of clock doesn't push the rest out of the ring
+	log(midi.NoteOn(0, 60, 100))
+	for range MonitorSize {
+		log(midi.TimingClock())
+		log(midi.Activesense())
+	}
+	if len(l.entries) != 1 || !l.entries[0].Message.Is(midi.NoteOnMsg) {
+		t.Fatalf("logged %d entries, want only the note", len(l.entries))
+	}
+
+	// unless it is being shown
+	l.clock.Store(true)
+	log(midi.TimingClock())
+	log(midi.Activesense())
+	if len(l.entries) != 3 {
+		t.Errorf("logged %d entries with clock on, want 3", len(l.entries))
+	}
+}
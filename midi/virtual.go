@@ -0,0 +1,69 @@
+package midi
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// virtualDriver is a MIDI driver that can create ports of its own for other
+// software to connect to. rtmidi can on Linux (ALSA, JACK) and macOS, not on
+// Windows.
+type virtualDriver interface {
+	OpenVirtualIn(name string) (drivers.In, error)
+	OpenVirtualOut(name string) (drivers.Out, error)
+}
+
+// The mixer's own virtual ports, guarded by driverMu. They're listed before
+// the driver's ports and stay open when the handler connects elsewhere.
+var (
+	virtualIn  drivers.In
+	virtualOut drivers.Out
+)
+
+// OpenVirtualPorts creates a virtual input and output port with the given
+// name, which other software on the machine can route MIDI into and out of
+func OpenVirtualPorts(name string) (drivers.In, drivers.Out, error) {
+	d := currentDriver()
+	vd, ok := d.(virtualDriver)
+	if !ok {
+		return nil, nil, fmt.Errorf("MIDI driver %v can't create virtual ports", d)
+	}
+
+	in, err := vd.OpenVirtualIn(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := vd.OpenVirtualOut(name)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+
+	CloseVirtualPorts()
+	driverMu.Lock()
+	defer driverMu.Unlock()
+	virtualIn, virtualOut = in, out
+	return in, out, nil
+}
+
+// CloseVirtualPorts removes the virtual ports, if any
+func CloseVirtualPorts() {
+	driverMu.Lock()
+	defer driverMu.Unlock()
+	if virtualIn != nil {
+		virtualIn.Close()
+		virtualIn = nil
+	}
+	if virtualOut != nil {
+		virtualOut.Close()
+		virtualOut = nil
+	}
+}
+
+// IsVirtual reports whether a port is one of the mixer's virtual ports
+func IsVirtual(port drivers.Port) bool {
+	driverMu.RLock()
+	defer driverMu.RUnlock()
+	return port != nil && (port == drivers.Port(virtualIn) || port == drivers.Port(virtualOut))
+}
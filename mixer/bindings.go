@@ -0,0 +1,444 @@
+package mixer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"midi-mixer/audio"
+	"midi-mixer/midi"
+)
+
+// TargetKind is a kind of mixer control that a MIDI controller can drive
+type TargetKind int
+
+const (
+	TargetVolume TargetKind = iota
+	TargetPan
+	TargetMute
+	TargetSolo
+	TargetMaster
+	TargetBPM
+	TargetPattern
+	TargetPlay
+	TargetStop
+	TargetRestart
+	TargetPrevPattern
+	TargetNextPattern
+	numTargetKinds
+)
+
+// targetKeys name the target kinds in bindings files
+var targetKeys = [numTargetKinds]string{
+	"volume", "pan", "mute", "solo", "master", "bpm", "pattern",
+	"play", "stop", "restart", "prev_pattern", "next_pattern",
+}
+
+// targetLabels name the target kinds on screen
+var targetLabels = [numTargetKinds]string{
+	"Volume", "Pan", "Mute", "Solo", "Master", "BPM", "Pattern",
+	"Play/Pause", "Stop", "Restart", "Prev Pattern", "Next Pattern",
+}
+
+func (k TargetKind) String() string {
+	if k >= 0 && k < numTargetKinds {
+		return targetLabels[k]
+	}
+	return "Unknown"
+}
+
+// PerChannel reports whether the target belongs to a channel strip
+func (k TargetKind) PerChannel() bool {
+	return k <= TargetSolo
+}
+
+// Continuous reports whether the target is set across a fader's or knob's
+// range rather than pressed like a button
+func (k TargetKind) Continuous() bool {
+	switch k {
+	case TargetVolume, TargetPan, TargetMaster, TargetBPM, TargetPattern:
+		return true
+	}
+	return false
+}
+
+// Target is a mixer control that can be bound to a MIDI controller
+type Target struct {
+	Kind    TargetKind
+	Channel int // Channel strip, for per-channel kinds
+}
+
+// Binding ties a Control Change, 14-bit CC pair, NRPN or note number on a
+// MIDI channel to a target. Faders and knobs set volume, pan, master, BPM
+// and pattern across their range; buttons and pads toggle mute and solo and
+// drive the transport when pressed.
+type Binding struct {
+	MIDIChannel uint8
+	Controller  uint8 // CC number, the MSB's for 14-bit CC, or note number for note bindings
+	Note        bool
+	HighRes     bool   // 14-bit CC: Controller, 0-31, carries the MSB and Controller+32 the LSB
+	NRPN        bool   // Bound to NRPN Parameter rather than a CC
+	Parameter   uint16 // NRPN parameter number, 0-16383
+	Target      Target
+}
+
+func (b Binding) String() string {
+	switch {
+	case b.Note:
+		return fmt.Sprintf("note %d on MIDI channel %d", b.Controller, b.MIDIChannel+1)
+	case b.NRPN:
+		return fmt.Sprintf("NRPN %d on MIDI channel %d", b.Parameter, b.MIDIChannel+1)
+	case b.HighRes:
+		return fmt.Sprintf("14-bit CC %d/%d on MIDI channel %d", b.Controller, b.Controller+midi.CCLSBOffset, b.MIDIChannel+1)
+	}
+	return fmt.Sprintf("CC %d on MIDI channel %d", b.Controller, b.MIDIChannel+1)
+}
+
+// sameControl reports whether two bindings are for the same control. A
+// 14-bit CC is the same control as its MSB alone.
+func (b Binding) sameControl(o Binding) bool {
+	switch {
+	case b.MIDIChannel != o.MIDIChannel || b.Note != o.Note || b.NRPN != o.NRPN:
+		return false
+	case b.NRPN:
+		return b.Parameter == o.Parameter
+	}
+	return b.Controller == o.Controller
+}
+
+// level returns the level a CC message sets the binding's target to, or
+// false if the message isn't from the binding's control. 14-bit values are
+// taken as they come; 7-bit ones are scaled up. d holds the MSBs of 14-bit
+// controls and may be nil for an unknown device.
+func (b Binding) level(d *device, msg midi.CCMessage) (uint16, bool) {
+	switch {
+	case b.Note || b.MIDIChannel != msg.Channel || b.NRPN != msg.NRPN:
+		return 0, false
+	case msg.NRPN:
+		return msg.Value14, b.Parameter == msg.Parameter
+	case b.HighRes && msg.Controller == b.Controller:
+		// The MSB starts a new value; the LSB usually follows
+		return uint16(msg.Value) << 7, true
+	case b.HighRes && msg.Controller == b.Controller+midi.CCLSBOffset && d != nil:
+		return uint16(d.msb[msg.Channel][b.Controller])<<7 | uint16(msg.Value), true
+	case !b.HighRes && msg.Controller == b.Controller:
+		return from7(msg.Value), true
+	}
+	return 0, false
+}
+
+// learnedMSB is a CC 0-31 just learned from a device for a fader-like
+// target. It becomes a 14-bit binding if the control's LSB comes next,
+// within learnLSBWindow.
+type learnedMSB struct {
+	device  int
+	binding Binding
+	at      time.Time
+}
+
+// learnLSBWindow is how soon after a learned MSB its LSB must arrive, so a
+// button pressed afterwards on CC 32-63 isn't taken for it
+const learnLSBWindow = 100 * time.Millisecond
+
+// LoadBindings reads the active bindings from path, which learning and
+// choosing a profile save back to: the default bindings, and those of
+// devices with their own. A missing file leaves the first bundled profile
+// in place; a broken one is reported and also falls back to it.
+func (s *State) LoadBindings(path string) error {
+	s.BindingsPath = path
+	s.setBindings(DefaultProfiles()[0])
+	s.DeviceBindings = map[string]Profile{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p, devices, err := parseBindings(data)
+	if err != nil {
+		return fmt.Errorf("bindings %s: %w", path, err)
+	}
+	s.DeviceBindings = devices
+	s.setBindings(p)
+	return nil
+}
+
+// setBindings makes a profile's bindings the default ones. Controllers are
+// sent the whole state again on the next SendFeedback.
+func (s *State) setBindings(p Profile) {
+	s.BindingsName = p.Name
+	s.Bindings = append([]Binding(nil), p.Bindings...)
+	s.resetFeedback()
+	s.updateBoundNotes()
+}
+
+// bindingsProfile returns the bindings a device uses, by device name: its
+// own if it has them, otherwise the default ones
+func (s *State) bindingsProfile(name string) Profile {
+	if p, ok := s.DeviceBindings[name]; ok {
+		return p
+	}
+	return Profile{Name: s.BindingsName, Bindings: s.Bindings}
+}
+
+// storeBindings replaces the bindings a device uses, by device name
+func (s *State) storeBindings(name string, bindings []Binding) {
+	if p, ok := s.DeviceBindings[name]; ok {
+		p.Bindings = bindings
+		s.DeviceBindings[name] = p
+	} else {
+		s.Bindings = bindings
+	}
+	s.updateBoundNotes()
+}
+
+// saveBindings writes the bindings to BindingsPath, creating its directory
+func (s *State) saveBindings() error {
+	if s.BindingsPath == "" {
+		return nil
+	}
+
+	data, err := marshalBindings(Profile{Name: s.BindingsName, Bindings: s.Bindings}, s.DeviceBindings)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.BindingsPath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.BindingsPath, data, 0o644)
+}
+
+// HandleCC applies an incoming Control Change to every target its device
+// binds it to. In learn mode it instead binds the control to the learn
+// target in the device's bindings, saves the bindings and returns the new
+// binding.
+func (s *State) HandleCC(msg midi.CCMessage) (*Binding, error) {
+	name := s.deviceName(msg.Device)
+	s.learnDevice = name
+	if s.Learning {
+		b := Binding{MIDIChannel: msg.Channel, Controller: msg.Controller, Target: s.LearnTarget()}
+		if msg.NRPN {
+			b = Binding{MIDIChannel: msg.Channel, NRPN: true, Parameter: msg.Parameter, Target: b.Target}
+		} else if msg.Controller < midi.CCLSBOffset && b.Target.Kind.Continuous() {
+			s.learnedMSB = &learnedMSB{device: msg.Device, binding: b, at: time.Now()}
+		}
+		s.bind(name, b)
+		s.setLearning(false)
+		return &b, s.saveBindings()
+	}
+	if l := s.learnedMSB; l != nil {
+		// A 14-bit control sends its LSB right after the MSB just learned
+		s.learnedMSB = nil
+		lsb := l.binding.Controller + midi.CCLSBOffset
+		if msg.Device == l.device && !msg.NRPN && msg.Channel == l.binding.MIDIChannel && msg.Controller == lsb &&
+			time.Since(l.at) < learnLSBWindow {
+			b := l.binding
+			b.HighRes = true
+			s.bind(name, b)
+			return &b, s.saveBindings()
+		}
+	}
+
+	d := s.devices[msg.Device]
+	if d != nil && !msg.NRPN && msg.Controller < midi.CCLSBOffset {
+		d.msb[msg.Channel][msg.Controller] = msg.Value
+	}
+	for _, b := range s.bindingsProfile(name).Bindings {
+		if level, ok := b.level(d, msg); ok {
+			s.applyBinding(b.Target, level)
+			s.markFeedback(msg.Device, b)
+		}
+	}
+	return nil, nil
+}
+
+// HandleNote applies a note to every target its device binds it to. A Note
+// On acts as a button press at its velocity; Note Off is a release, which
+// fader-like targets ignore so they keep the level the note set. In learn
+// mode a Note On instead binds the note to the learn target, as HandleCC
+// does for controls, and returns the new binding.
+func (s *State) HandleNote(msg midi.NoteMessage) (*Binding, error) {
+	name := s.deviceName(msg.Device)
+	s.learnDevice = name
+	if s.Learning {
+		if msg.Velocity == 0 {
+			return nil, nil
+		}
+		b := Binding{MIDIChannel: msg.Channel, Controller: msg.Key, Note: true, Target: s.LearnTarget()}
+		s.bind(name, b)
+		s.setLearning(false)
+		return &b, s.saveBindings()
+	}
+	for _, b := range s.bindingsProfile(name).Bindings {
+		if msg.Velocity == 0 && b.Target.Kind.Continuous() {
+			continue
+		}
+		if b.Note && b.MIDIChannel == msg.Channel && b.Controller == msg.Key {
+			s.applyBinding(b.Target, from7(msg.Velocity))
+			s.markFeedback(msg.Device, b)
+		}
+	}
+	return nil, nil
+}
+
+// BoundNotes returns the channel of incoming notes that drive bindings
+// rather than voices
+func (s *State) BoundNotes() <-chan midi.NoteMessage {
+	return s.boundNoteChan
+}
+
+// boundNote identifies a note used by a binding of a device
+type boundNote struct {
+	device       int
+	channel, key uint8
+}
+
+// updateBoundNotes publishes the notes each device's bindings use to the
+// note goroutine
+func (s *State) updateBoundNotes() {
+	notes := map[boundNote]bool{}
+	for id, d := range s.devices {
+		for _, b := range s.bindingsProfile(d.name).Bindings {
+			if b.Note {
+				notes[boundNote{id, b.MIDIChannel, b.Controller}] = true
+			}
+		}
+	}
+	s.boundNotes.Store(&notes)
+}
+
+// bind adds a binding to a device's bindings, replacing whatever the control
+// or the target was bound to before
+func (s *State) bind(name string, b Binding) {
+	kept := []Binding{b}
+	for _, old := range s.bindingsProfile(name).Bindings {
+		if !old.sameControl(b) && old.Target != b.Target {
+			kept = append(kept, old)
+		}
+	}
+	s.storeBindings(name, kept)
+}
+
+// applyBinding sets a target from a controller's 14-bit level
+func (s *State) applyBinding(t Target, value uint16) {
+	if t.Kind.PerChannel() && (t.Channel < 0 || t.Channel >= len(s.Channels)) {
+		return
+	}
+
+	switch t.Kind {
+	case TargetVolume:
+		s.SetChannelVolume(t.Channel, value)
+	case TargetPan:
+		s.SetChannelPan(t.Channel, value)
+	case TargetMute:
+		if value > 0 {
+			s.toggleMute(&s.Channels[t.Channel])
+		}
+	case TargetSolo:
+		if value > 0 {
+			s.toggleSolo(&s.Channels[t.Channel])
+		}
+	case TargetMaster:
+		s.SetMasterVolume(value)
+	case TargetBPM:
+		if s.AudioEngine != nil && !s.AudioEngine.Following() {
+			s.SetBPM(audio.MinBPM + int(value)*(audio.MaxBPM-audio.MinBPM)/audio.MaxLevel)
+		}
+	case TargetPattern:
+		if s.AudioEngine != nil {
+			s.SetPattern(int(value) * s.AudioEngine.PatternCount() / (audio.MaxLevel + 1))
+		}
+	}
+
+	// The rest are buttons, acting when pressed
+	if value == 0 {
+		return
+	}
+	switch t.Kind {
+	case TargetPlay:
+		s.TogglePlay()
+	case TargetStop:
+		s.Stop()
+	case TargetRestart:
+		s.Restart()
+	case TargetPrevPattern:
+		s.PrevPattern()
+	case TargetNextPattern:
+		s.NextPattern()
+	}
+}
+
+// StartLearn enters learn mode: the next control moved on the MIDI input is
+// bound to the learn target
+func (s *State) StartLearn() {
+	s.setLearning(true)
+	s.EditMode = false
+}
+
+// StopLearn leaves learn mode without binding anything
+func (s *State) StopLearn() {
+	s.setLearning(false)
+}
+
+// setLearning enters or leaves learn mode. While learning, notes go to
+// HandleNote rather than the voices, so pads can be learned too.
+func (s *State) setLearning(on bool) {
+	s.Learning = on
+	s.learningNotes.Store(on)
+}
+
+// CycleLearnTarget moves the learn target to the next or previous kind of
+// control. Per-channel targets apply to the selected channel.
+func (s *State) CycleLearnTarget(delta int) {
+	s.LearnKind = TargetKind((int(s.LearnKind) + delta + int(numTargetKinds)) % int(numTargetKinds))
+}
+
+// LearnTarget returns the control that learning will bind
+func (s *State) LearnTarget() Target {
+	t := Target{Kind: s.LearnKind}
+	if t.Kind.PerChannel() {
+		t.Channel = s.SelectedIndex
+	}
+	return t
+}
+
+// Unbind removes the binding of the learn target from the bindings of the
+// device last used and saves the bindings
+func (s *State) Unbind() error {
+	target := s.LearnTarget()
+	kept := []Binding{}
+	for _, b := range s.bindingsProfile(s.learnDevice).Bindings {
+		if b.Target != target {
+			kept = append(kept, b)
+		}
+	}
+	s.storeBindings(s.learnDevice, kept)
+	return s.saveBindings()
+}
+
+// BindingFor returns the binding of a target in the bindings of the device
+// last used, if it has one
+func (s *State) BindingFor(t Target) (Binding, bool) {
+	for _, b := range s.bindingsProfile(s.learnDevice).Bindings {
+		if b.Target == t {
+			return b, true
+		}
+	}
+	return Binding{}, false
+}
+
+// TargetName describes a target for display, e.g. "KICK Volume"
+func (s *State) TargetName(t Target) string {
+	if !t.Kind.PerChannel() {
+		return t.Kind.String()
+	}
+	if t.Channel >= 0 && t.Channel < len(s.Channels) {
+		return s.Channels[t.Channel].Name + " " + t.Kind.String()
+	}
+	return t.Kind.String()
+}
@@ -0,0 +1,121 @@
+package mixer
+
+import (
+	"testing"
+
+	gomidi "gitlab.com/gomidi/midi/v2"
+
+	"midi-mixer/midi"
+)
+
+func TestHighResBindings(t *testing.T) {
+	s, drv, _ := connectLoopback(t, false)
+	s.setBindings(Profile{Name: "Test", Bindings: []Binding{
+		{Controller: 1, HighRes: true, Target: Target{Kind: TargetVolume}},
+		{NRPN: true, Parameter: 130, Target: Target{Kind: TargetPan}},
+	}})
+
+	// The MSB moves the fader in coarse steps and the LSB fills in the rest
+	controllerCC(t, s, drv, 1, 100)
+	if got := s.Channels[0].Volume; got != 100<<7 {
+		t.Errorf("volume after MSB = %d, want %d", got, 100<<7)
+	}
+	controllerCC(t, s, drv, 1+midi.CCLSBOffset, 50)
+	if got := s.Channels[0].Volume; got != 100<<7|50 {
+		t.Errorf("volume after LSB = %d, want %d", got, 100<<7|50)
+	}
+
+	// An LSB pairs with the last MSB of its own control
+	controllerCC(t, s, drv, 2, 10)
+	controllerCC(t, s, drv, 1+midi.CCLSBOffset, 7)
+	if got := s.Channels[0].Volume; got != 100<<7|7 {
+		t.Errorf("volume = %d, want %d", got, 100<<7|7)
+	}
+
+	inject(t, drv, gomidi.ControlChange(0, midi.CCNRPNMSB, 1))
+	inject(t, drv, gomidi.ControlChange(0, midi.CCNRPNLSB, 2))
+	controllerCC(t, s, drv, midi.CCDataEntry, 64)
+	controllerCC(t, s, drv, midi.CCDataEntryLSB, 1)
+	if got := s.Channels[0].Pan; got != 64<<7|1 {
+		t.Errorf("pan = %d, want %d", got, 64<<7|1)
+	}
+}
+
+func TestLearnHighRes(t *testing.T) {
+	s, drv, _ := connectLoopback(t, false)
+	s.SelectedIndex = 2
+	s.LearnKind = TargetVolume
+
+	// An MSB followed by its LSB is learned as a 14-bit pair
+	s.StartLearn()
+	controllerCC(t, s, drv, 4, 90)
+	controllerCC(t, s, drv, 4+midi.CCLSBOffset, 3)
+	b, ok := s.BindingFor(Target{Kind: TargetVolume, Channel: 2})
+	if !ok || !b.HighRes || b.Controller != 4 {
+		t.Fatalf("learned %v, want 14-bit CC 4", b)
+	}
+
+	// An MSB alone stays a 7-bit control
+	s.LearnKind = TargetPan
+	s.StartLearn()
+	controllerCC(t, s, drv, 5, 90)
+	controllerCC(t, s, drv, 20, 3)
+	if b, ok := s.BindingFor(Target{Kind: TargetPan, Channel: 2}); !ok || b.HighRes || b.Controller != 5 {
+		t.Errorf("learned %v, want 7-bit CC 5", b)
+	}
+}
+
+func TestHighResFeedback(t *testing.T) {
+	s, drv, _ := connectLoopback(t, false)
+	s.setBindings(Profile{Name: "Test", Bindings: []Binding{
+		{Controller: 1, HighRes: true, Target: Target{Kind: TargetVolume}},
+		{NRPN: true, Parameter: 130, Target: Target{Kind: TargetPan}},
+	}})
+	s.SendFeedback()
+	drv.Out.Sent()
+
+	s.SetChannelVolume(0, 100<<7|50)
+	s.SetChannelPan(0, 3<<7|9)
+	s.SendFeedback()
+	checkSent(t, drv,
+		gomidi.ControlChange(0, 1, 100),
+		gomidi.ControlChange(0, 1+midi.CCLSBOffset, 50),
+		gomidi.ControlChange(0, midi.CCNRPNMSB, 1),
+		gomidi.ControlChange(0, midi.CCNRPNLSB, 2),
+		gomidi.ControlChange(0, midi.CCDataEntry, 3),
+		gomidi.ControlChange(0, midi.CCDataEntryLSB, 9),
+	)
+}
+
+func TestLearnNote(t *testing.T) {
+	s, drv, _ := connectLoopback(t, false)
+	s.SelectedIndex = 1
+	s.LearnKind = TargetSolo
+	s.StartLearn()
+
+	// A pad sending notes is learned like a button sending CCs
+	inject(t, drv, gomidi.NoteOn(2, 44, 90))
+	b, err := s.HandleNote(receive(t, s.BoundNotes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Binding{MIDIChannel: 2, Controller: 44, Note: true, Target: Target{Kind: TargetSolo, Channel: 1}}
+	if b == nil || *b != want {
+		t.Fatalf("learned %v, want %v", b, want)
+	}
+	if s.Learning {
+		t.Error("still learning")
+	}
+	if s.Channels[1].Solo {
+		t.Error("learning the pad also pressed it")
+	}
+
+	// The note now drives the target
+	inject(t, drv, gomidi.NoteOff(2, 44))
+	s.HandleNote(receive(t, s.BoundNotes()))
+	inject(t, drv, gomidi.NoteOn(2, 44, 90))
+	s.HandleNote(receive(t, s.BoundNotes()))
+	if !s.Channels[1].Solo {
+		t.Error("learned pad didn't toggle solo")
+	}
+}
@@ -0,0 +1,77 @@
+package mixer
+
+import (
+	"time"
+
+	"midi-mixer/audio"
+)
+
+// Limits on how far the clock sender may drift from its time anchor before
+// it re-anchors, e.g. after the audio device stalls
+const (
+	maxClockLag  = 20 * time.Millisecond
+	maxClockLead = 500 * time.Millisecond
+)
+
+// runClock forwards the engine's MIDI clock events to the MIDI output. The
+// engine renders audio a buffer at a time, so events arrive in bursts; each
+// one is held until its frame's due time to keep the outgoing clock steady.
+func (s *State) runClock(events <-chan audio.ClockEvent, done <-chan struct{}) {
+	var anchorTime time.Time
+	var anchorFrame int64
+
+	for {
+		var ev audio.ClockEvent
+		select {
+		case <-done:
+			return
+		case ev = <-events:
+		}
+
+		now := time.Now()
+		due := anchorTime.Add(audio.FrameDuration(ev.Frame - anchorFrame))
+		if anchorTime.IsZero() || now.Sub(due) > maxClockLag || due.Sub(now) > maxClockLead {
+			anchorTime, anchorFrame, due = now, ev.Frame, now
+		}
+		if wait := due.Sub(now); wait > 0 {
+			select {
+			case <-done:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		switch ev.Kind {
+		case audio.ClockTick:
+			s.MidiHandler.SendClock()
+		case audio.ClockStart:
+			s.MidiHandler.SendStart()
+		case audio.ClockStop:
+			s.MidiHandler.SendStop()
+		case audio.ClockContinue:
+			s.MidiHandler.SendContinue()
+		case audio.ClockSongPosition:
+			s.MidiHandler.SendSongPosition(uint16(ev.Position))
+		}
+	}
+}
+
+// ToggleClockOutput turns MIDI clock output on or off
+func (s *State) ToggleClockOutput() {
+	s.SetClockOutput(!s.ClockOutput())
+}
+
+// SetClockOutput turns MIDI clock output on or off
+func (s *State) SetClockOutput(enabled bool) {
+	if s.AudioEngine != nil {
+		s.AudioEngine.SetClockOutput(enabled)
+	}
+}
+
+// ClockOutput reports whether MIDI clock is being sent
+func (s *State) ClockOutput() bool {
+	if s.AudioEngine != nil {
+		return s.AudioEngine.ClockOutput()
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package mixer
+
+import (
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+
+	"midi-mixer/midi"
+)
+
+// MCUMapping names the Mackie Control mapping of a device, offered after
+// the profiles
+const MCUMapping = "Mackie Control"
+
+// device is what the mixer keeps for a connected MIDI device: the feedback
+// sent to it and, for an MCU surface, which channels its strips show
+type device struct {
+	name         string
+	feedbackSent map[Binding]uint16 // Last value sent to each bound control
+	mcuSent      *mcuSurface        // Last state sent to an MCU surface
+	mcuBank      int                // First channel on the MCU strips
+	mcuTouched   [midi.MCUStrips + 1]bool
+	msb          [16][32]uint8 // Last MSB of each 14-bit control, by MIDI channel and CC
+	programSent  int           // Pattern last sent or picked by Program Change
+}
+
+// Connect opens a MIDI input and output port, either of which may be nil,
+// as a device alongside those already connected, sends it the whole mixer
+// state and returns its ID. Devices on the same ports are replaced.
+func (s *State) Connect(in drivers.In, out drivers.Out) (int, error) {
+	s.forgetLost(midi.Device{In: in, Out: out})
+	id, err := s.MidiHandler.AddDevice(in, out)
+	if err != nil {
+		s.syncDevices()
+		return 0, err
+	}
+	// Program Changes are sent when the pattern changes, not on connecting
+	s.devices[id] = &device{name: midi.Device{In: in, Out: out}.Name(), programSent: s.GetPatternIndex()}
+	s.syncDevices()
+	s.SendFeedback()
+	return id, nil
+}
+
+// Disconnect closes a device's ports, or stops waiting for a lost device to
+// come back
+func (s *State) Disconnect(id int) {
+	s.lostDevices = slices.DeleteFunc(s.lostDevices, func(d midi.Device) bool {
+		return d.ID == id
+	})
+	s.MidiHandler.RemoveDevice(id)
+	s.syncDevices()
+}
+
+// LostDevices returns the devices whose ports went away, in the order they
+// were lost. They're reconnected when their ports are back.
+func (s *State) LostDevices() []midi.Device {
+	return s.lostDevices
+}
+
+// HandlePorts disconnects devices whose ports have gone and reconnects lost
+// devices whose ports are all back, by name, with the channel filter and
+// mapping they had. It returns the names of the devices lost and found.
+func (s *State) HandlePorts(msg midi.PortsMessage) (lost, found []string) {
+	for _, d := range s.Devices() {
+		if portsGone(d, msg) {
+			s.MidiHandler.RemoveDevice(d.ID)
+			s.lostDevices = append(s.lostDevices, d)
+			lost = append(lost, d.Name())
+		}
+	}
+	s.syncDevices()
+
+	var back []midi.Device
+	s.lostDevices = slices.DeleteFunc(s.lostDevices, func(d midi.Device) bool {
+		if portsGone(d, msg) {
+			return false
+		}
+		back = append(back, d)
+		return true
+	})
+	for _, d := range back {
+		var in drivers.In
+		var out drivers.Out
+		if d.In != nil {
+			in = msg.In(d.In)
+		}
+		if d.Out != nil {
+			out = msg.Out(d.Out)
+		}
+		id, err := s.Connect(in, out)
+		if err != nil {
+			// Not ready yet, such as a device still starting up
+			s.lostDevices = append(s.lostDevices, d)
+			continue
+		}
+		s.SetDeviceChannel(id, d.Channel)
+		s.SetDeviceMCU(id, d.MCU)
+		found = append(found, d.Name())
+	}
+	return lost, found
+}
+
+// portsGone reports whether any of a device's ports is missing from msg
+func portsGone(d midi.Device, msg midi.PortsMessage) bool {
+	return (d.In != nil && msg.In(d.In) == nil) || (d.Out != nil && msg.Out(d.Out) == nil)
+}
+
+// forgetLost stops waiting for lost devices on either of a device's ports,
+// as it's being connected anew
+func (s *State) forgetLost(device midi.Device) {
+	s.lostDevices = slices.DeleteFunc(s.lostDevices, func(d midi.Device) bool {
+		return (d.In != nil && device.In != nil && d.In.String() == device.In.String()) ||
+			(d.Out != nil && device.Out != nil && d.Out.String() == device.Out.String())
+	})
+}
+
+// Devices returns the connected MIDI devices
+func (s *State) Devices() []midi.Device {
+	if s.MidiHandler == nil {
+		return nil
+	}
+	return s.MidiHandler.Devices()
+}
+
+// Dropped returns how many incoming MIDI messages have been dropped because
+// the mixer couldn't keep up
+func (s *State) Dropped() uint64 {
+	if s.MidiHandler == nil {
+		return s.droppedNotes.Load()
+	}
+	return s.MidiHandler.Dropped() + s.droppedNotes.Load()
+}
+
+// syncDevices forgets devices the handler no longer has, such as those
+// replaced by a new connection on the same ports
+func (s *State) syncDevices() {
+	connected := map[int]bool{}
+	for _, d := range s.Devices() {
+		connected[d.ID] = true
+	}
+	for id := range s.devices {
+		if !connected[id] {
+			delete(s.devices, id)
+		}
+	}
+	s.updateBoundNotes()
+}
+
+// deviceName returns the name of a connected device, or "" if it's unknown
+func (s *State) deviceName(id int) string {
+	if d := s.devices[id]; d != nil {
+		return d.name
+	}
+	return ""
+}
+
+// SetDeviceChannel makes a device's input accept only one MIDI channel,
+// 0-15, or midi.AllChannels
+func (s *State) SetDeviceChannel(id, channel int) {
+	s.MidiHandler.SetDeviceChannel(id, channel)
+}
+
+// SetDeviceMCU switches a device between its bindings and Mackie Control.
+// It's sent the whole mixer state in its new mapping on the next
+// SendFeedback.
+func (s *State) SetDeviceMCU(id int, on bool) {
+	s.MidiHandler.SetDeviceMCU(id, on)
+	if d := s.devices[id]; d != nil {
+		d.feedbackSent = nil
+		d.mcuSent = nil
+	}
+	s.updateBoundNotes()
+}
+
+// DeviceMapping names the mapping a device uses: MCUMapping, or the name of
+// its bindings
+func (s *State) DeviceMapping(d midi.Device) string {
+	if d.MCU {
+		return MCUMapping
+	}
+	return s.bindingsProfile(d.Name()).Name
+}
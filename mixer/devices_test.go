@@ -0,0 +1,81 @@
+package mixer
+
+import (
+	"slices"
+	"testing"
+
+	gomidi "gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/drivers"
+
+	"midi-mixer/midi"
+	"midi-mixer/midi/loopback"
+)
+
+// ports lists the ports of loopback drivers as the port watcher would
+func ports(drvs ...*loopback.Driver) midi.PortsMessage {
+	var msg midi.PortsMessage
+	for _, drv := range drvs {
+		msg.Ins = append(msg.Ins, drv.In)
+		msg.Outs = append(msg.Outs, drv.Out)
+	}
+	return msg
+}
+
+func TestHandlePorts(t *testing.T) {
+	s, drv, id := connectLoopback(t, false)
+	s.SetDeviceChannel(id, 4)
+	other := loopback.New("Other")
+	if _, err := s.Connect(other.In, other.Out); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unplugging one controller loses only that one
+	lost, found := s.HandlePorts(ports(other))
+	if !slices.Equal(lost, []string{"Test In"}) || found != nil {
+		t.Fatalf("lost %q and found %q, want Test In lost", lost, found)
+	}
+	if devices := s.Devices(); len(devices) != 1 || devices[0].Name() != "Other In" {
+		t.Fatalf("connected devices %v, want only Other In", devices)
+	}
+	if lostDevices := s.LostDevices(); len(lostDevices) != 1 || lostDevices[0].Name() != "Test In" {
+		t.Fatalf("lost devices %v, want Test In", lostDevices)
+	}
+	if drv.Out.IsOpen() {
+		t.Error("lost device's output is still open")
+	}
+	if err := drv.In.Inject(gomidi.ControlChange(0, 7, 1)); err == nil {
+		t.Error("lost device's input is still listened to")
+	}
+
+	// A different port appearing doesn't bring it back
+	lost, found = s.HandlePorts(ports(other, loopback.New("Stranger")))
+	if lost != nil || found != nil {
+		t.Fatalf("lost %q and found %q, want nothing", lost, found)
+	}
+
+	// Plugging it back in gives new port objects with the same names, which
+	// are reconnected with the channel filter the device had
+	replugged := loopback.New("Test")
+	lost, found = s.HandlePorts(ports(other, replugged))
+	if lost != nil || !slices.Equal(found, []string{"Test In"}) {
+		t.Fatalf("lost %q and found %q, want Test In found", lost, found)
+	}
+	if len(s.LostDevices()) != 0 {
+		t.Errorf("still waiting for %v", s.LostDevices())
+	}
+	var back *midi.Device
+	for _, d := range s.Devices() {
+		if d.Name() == "Test In" {
+			back = &d
+		}
+	}
+	if back == nil {
+		t.Fatalf("Test In not reconnected, devices %v", s.Devices())
+	}
+	if back.In != drivers.In(replugged.In) || back.Out != drivers.Out(replugged.Out) || back.Channel != 4 {
+		t.Errorf("reconnected %+v, want the new ports on channel 4", *back)
+	}
+	if len(replugged.Out.Sent()) == 0 {
+		t.Error("reconnected controller wasn't sent the mixer state")
+	}
+}
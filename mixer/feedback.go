@@ -0,0 +1,158 @@
+package mixer
+
+import (
+	"slices"
+
+	"midi-mixer/audio"
+)
+
+// SendFeedback sends each connected device's bound controls the current
+// value of their targets if it changed since last sent, however it changed:
+// keys, another control or device, a profile switch or the engine itself.
+// Controls bound by CC get a Control Change, or an MSB and LSB for 14-bit
+// CC; NRPN controls get their parameter set; those bound by note get a Note
+// On lighting the LED, or Note Off. MCU surfaces are sent their faders, LEDs
+// and LCD instead. Other devices are sent a Program Change when the pattern
+// changes.
+func (s *State) SendFeedback() {
+	for _, info := range s.Devices() {
+		d := s.devices[info.ID]
+		if d == nil || info.Out == nil {
+			continue
+		}
+		if info.MCU {
+			s.sendMCUFeedback(info.ID, d)
+		} else {
+			s.sendBindingFeedback(info.ID, d)
+			s.sendProgram(info.ID, d)
+		}
+	}
+}
+
+// sendBindingFeedback sends a device the values of its bound targets that
+// changed since last sent
+func (s *State) sendBindingFeedback(id int, d *device) {
+	if d.feedbackSent == nil {
+		d.feedbackSent = map[Binding]uint16{}
+	}
+
+	profile := s.bindingsProfile(d.name)
+	for _, b := range profile.Bindings {
+		value, ok := s.bindingFeedback(profile, b)
+		if !ok {
+			continue
+		}
+		if sent, seen := d.feedbackSent[b]; seen && sent == value {
+			continue
+		}
+
+		var err error
+		switch {
+		case b.Note:
+			err = s.MidiHandler.SendNote(id, b.MIDIChannel, b.Controller, uint8(value))
+		case b.NRPN:
+			err = s.MidiHandler.SendNRPN(id, b.MIDIChannel, b.Parameter, value)
+		case b.HighRes:
+			err = s.MidiHandler.SendCC14(id, b.MIDIChannel, b.Controller, value)
+		default:
+			err = s.MidiHandler.SendCC(id, b.MIDIChannel, b.Controller, uint8(value))
+		}
+		if err == nil {
+			d.feedbackSent[b] = value
+		}
+	}
+}
+
+// resetFeedback makes the next SendFeedback send every device everything
+func (s *State) resetFeedback() {
+	for _, d := range s.devices {
+		d.feedbackSent = nil
+		d.mcuSent = nil
+	}
+}
+
+// feedbackValue returns the 14-bit level showing a target's state, or false
+// for buttons that have no state to show
+func (s *State) feedbackValue(t Target) (uint16, bool) {
+	if t.Kind.PerChannel() && (t.Channel < 0 || t.Channel >= len(s.Channels)) {
+		return 0, false
+	}
+
+	switch t.Kind {
+	case TargetVolume:
+		return s.Channels[t.Channel].Volume, true
+	case TargetPan:
+		return s.Channels[t.Channel].Pan, true
+	case TargetMute:
+		return ledValue(s.Channels[t.Channel].Mute), true
+	case TargetSolo:
+		return ledValue(s.Channels[t.Channel].Solo), true
+	case TargetMaster:
+		return s.MasterVolume, true
+	case TargetBPM:
+		// Inverse of applyBinding, rounded so a fader isn't nudged back
+		steps := audio.MaxBPM - audio.MinBPM
+		bpm := min(max(s.GetBPM(), audio.MinBPM), audio.MaxBPM)
+		return uint16(((bpm-audio.MinBPM)*audio.MaxLevel + steps/2) / steps), true
+	case TargetPattern:
+		// The lowest 7-bit value selecting the pattern, which selects it at
+		// 14 bits too
+		if s.AudioEngine == nil || s.AudioEngine.PatternCount() == 0 {
+			return 0, false
+		}
+		count := s.AudioEngine.PatternCount()
+		return from7(uint8(min((s.GetPatternIndex()*128+count-1)/count, 127))), true
+	case TargetPlay:
+		return ledValue(s.Transport() == audio.TransportPlaying), true
+	case TargetStop:
+		return ledValue(s.Transport() == audio.TransportStopped), true
+	}
+	return 0, false
+}
+
+// bindingFeedback returns the value sent to a bound control of a device
+// using profile. A volume control on a device with no mute button for its
+// channel shows a mute as volume 0, the only way a plain fader can show it.
+func (s *State) bindingFeedback(profile Profile, b Binding) (uint16, bool) {
+	level, ok := s.feedbackValue(b.Target)
+	if !ok {
+		return 0, false
+	}
+	if b.Target.Kind == TargetVolume && s.Channels[b.Target.Channel].Mute &&
+		!slices.ContainsFunc(profile.Bindings, func(o Binding) bool {
+			return o.Target == Target{Kind: TargetMute, Channel: b.Target.Channel}
+		}) {
+		level = 0
+	}
+	return b.controlValue(level), true
+}
+
+// markFeedback records a control's own value as sent after it moved a
+// fader-like target, so the controller isn't echoed the value it just sent.
+// Buttons are left to SendFeedback, which lights them with the new state.
+func (s *State) markFeedback(id int, b Binding) {
+	d := s.devices[id]
+	if d == nil || d.feedbackSent == nil || !b.Target.Kind.Continuous() {
+		return
+	}
+	if value, ok := s.bindingFeedback(s.bindingsProfile(d.name), b); ok {
+		d.feedbackSent[b] = value
+	}
+}
+
+// controlValue converts a level to the value a binding's control is sent:
+// 14-bit for 14-bit CC and NRPN, 7-bit otherwise
+func (b Binding) controlValue(level uint16) uint16 {
+	if b.HighRes || b.NRPN {
+		return level
+	}
+	return uint16(to7(level))
+}
+
+// ledValue is the level of a lit or unlit button
+func ledValue(on bool) uint16 {
+	if on {
+		return audio.MaxLevel
+	}
+	return 0
+}
@@ -0,0 +1,205 @@
+package mixer
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	gomidi "gitlab.com/gomidi/midi/v2"
+
+	"midi-mixer/audio"
+	"midi-mixer/midi/loopback"
+)
+
+// testBindings bind channel 1's volume to CC 7, its mute button to CC 20
+// and its solo pad to note 40, all on MIDI channel 1
+var testBindings = Profile{Name: "Test", Bindings: []Binding{
+	{Controller: 7, Target: Target{Kind: TargetVolume}},
+	{Controller: 20, Target: Target{Kind: TargetMute}},
+	{Controller: 40, Note: true, Target: Target{Kind: TargetSolo}},
+}}
+
+// connectBound returns an offline mixer with a loopback controller using
+// testBindings, with the state dump sent on connecting already read
+func connectBound(t *testing.T) (*State, *loopback.Driver) {
+	t.Helper()
+	s, drv, _ := connectLoopback(t, false)
+	s.setBindings(testBindings)
+	s.SendFeedback()
+	drv.Out.Sent()
+	return s, drv
+}
+
+// checkSent checks the bytes sent to a loopback controller since last read
+func checkSent(t *testing.T, drv *loopback.Driver, want ...gomidi.Message) {
+	t.Helper()
+	format := func(msgs []gomidi.Message) []string {
+		var out []string
+		for _, m := range msgs {
+			out = append(out, fmt.Sprintf("% X", []byte(m)))
+		}
+		return out
+	}
+	if got := format(drv.Out.Sent()); !slices.Equal(got, format(want)) {
+		t.Errorf("sent %q, want %q", got, format(want))
+	}
+}
+
+// controllerCC sends a CC from the loopback controller through the mixer
+func controllerCC(t *testing.T, s *State, drv *loopback.Driver, cc, value uint8) {
+	t.Helper()
+	inject(t, drv, gomidi.ControlChange(0, cc, value))
+	for _, msg := range s.MidiHandler.NextCC() {
+		if _, err := s.HandleCC(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// controllerNote plays a note on the loopback controller through the mixer
+func controllerNote(t *testing.T, s *State, drv *loopback.Driver, key, velocity uint8) {
+	t.Helper()
+	inject(t, drv, gomidi.NoteOn(0, key, velocity))
+	s.HandleNote(receive(t, s.BoundNotes()))
+}
+
+func TestFeedbackOnConnect(t *testing.T) {
+	// The default bindings are CC 7 volume and CC 10 pan on each strip's
+	// MIDI channel. FX starts muted, which shows as volume 0.
+	_, drv, _ := connectLoopback(t, false)
+	var want []gomidi.Message
+	for ch := uint8(0); ch < 8; ch++ {
+		volume := to7(DefaultVolume)
+		if ch == audio.ChFX {
+			volume = 0
+		}
+		want = append(want, gomidi.ControlChange(ch, 7, volume), gomidi.ControlChange(ch, 10, 64))
+	}
+	checkSent(t, drv, want...)
+}
+
+func TestMuteFeedbackWithoutMuteBinding(t *testing.T) {
+	// The default bindings have no mute buttons, so mute shows on CC 7
+	s, drv, _ := connectLoopback(t, false)
+	drv.Out.Sent()
+	s.SelectedIndex = 2
+
+	s.ToggleMute()
+	s.SendFeedback()
+	checkSent(t, drv, gomidi.ControlChange(2, 7, 0))
+
+	// Volume changes while muted aren't shown
+	s.AdjustVolume(1)
+	s.SendFeedback()
+	checkSent(t, drv)
+
+	s.ToggleMute()
+	s.SendFeedback()
+	checkSent(t, drv, gomidi.ControlChange(2, 7, to7(s.Channels[2].Volume)))
+
+	// With a mute button bound, the fader keeps showing the level
+	s, drv = connectBound(t)
+	s.SelectedIndex = 0
+	s.ToggleMute()
+	s.SendFeedback()
+	checkSent(t, drv, gomidi.ControlChange(0, 20, 127))
+}
+
+func TestFeedbackOnBindingsChange(t *testing.T) {
+	s, drv, _ := connectLoopback(t, false)
+	drv.Out.Sent()
+	s.setBindings(testBindings)
+	s.SendFeedback()
+	checkSent(t, drv,
+		gomidi.ControlChange(0, 7, to7(DefaultVolume)),
+		gomidi.ControlChange(0, 20, 0),
+		gomidi.NoteOff(0, 40),
+	)
+}
+
+func TestFaderEchoSuppressed(t *testing.T) {
+	s, drv := connectBound(t)
+
+	// The fader isn't sent back the value it just sent
+	controllerCC(t, s, drv, 7, 90)
+	if s.Channels[0].Volume != from7(90) {
+		t.Errorf("volume = %d, want %d", s.Channels[0].Volume, from7(90))
+	}
+	s.SendFeedback()
+	checkSent(t, drv)
+
+	// but is moved when the volume changes elsewhere
+	s.SelectedIndex = 0
+	s.AdjustVolume(1)
+	s.SendFeedback()
+	checkSent(t, drv, gomidi.ControlChange(0, 7, 91))
+
+	// and only once
+	s.SendFeedback()
+	checkSent(t, drv)
+}
+
+func TestMuteSoloLEDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		toggle func(t *testing.T, s *State, drv *loopback.Driver)
+		on     gomidi.Message
+		off    gomidi.Message
+		state  func(s *State) bool
+	}{
+		{
+			name:   "mute from keys",
+			toggle: func(t *testing.T, s *State, drv *loopback.Driver) { s.ToggleMute() },
+			on:     gomidi.ControlChange(0, 20, 127),
+			off:    gomidi.ControlChange(0, 20, 0),
+			state:  func(s *State) bool { return s.Channels[0].Mute },
+		},
+		{
+			name: "mute from controller",
+			toggle: func(t *testing.T, s *State, drv *loopback.Driver) {
+				controllerCC(t, s, drv, 20, 127)
+				controllerCC(t, s, drv, 20, 0)
+			},
+			on:    gomidi.ControlChange(0, 20, 127),
+			off:   gomidi.ControlChange(0, 20, 0),
+			state: func(s *State) bool { return s.Channels[0].Mute },
+		},
+		{
+			name:   "solo from keys",
+			toggle: func(t *testing.T, s *State, drv *loopback.Driver) { s.ToggleSolo() },
+			on:     gomidi.NoteOn(0, 40, 127),
+			off:    gomidi.NoteOff(0, 40),
+			state:  func(s *State) bool { return s.Channels[0].Solo },
+		},
+		{
+			name: "solo from controller",
+			toggle: func(t *testing.T, s *State, drv *loopback.Driver) {
+				controllerNote(t, s, drv, 40, 100)
+				controllerNote(t, s, drv, 40, 0)
+			},
+			on:    gomidi.NoteOn(0, 40, 127),
+			off:   gomidi.NoteOff(0, 40),
+			state: func(s *State) bool { return s.Channels[0].Solo },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, drv := connectBound(t)
+			s.SelectedIndex = 0
+
+			tt.toggle(t, s, drv)
+			if !tt.state(s) {
+				t.Fatal("not toggled on")
+			}
+			s.SendFeedback()
+			checkSent(t, drv, tt.on)
+
+			tt.toggle(t, s, drv)
+			if tt.state(s) {
+				t.Fatal("not toggled off")
+			}
+			s.SendFeedback()
+			checkSent(t, drv, tt.off)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package mixer
+
+import (
+	"math"
+	"time"
+
+	"midi-mixer/audio"
+	"midi-mixer/midi"
+)
+
+// Incoming clock tempo tracking
+const (
+	// tempoSmoothing is the weight of each new tick interval in the running
+	// average, enough to ride out MIDI and scheduler jitter within a beat
+	tempoSmoothing = 0.05
+
+	// maxTickGap is the longest tick interval still treated as a running
+	// clock (12.5 BPM); anything longer restarts the measurement
+	maxTickGap = 200 * time.Millisecond
+
+	// minTempoChange is the smallest measured change passed to the engine
+	minTempoChange = 0.05
+)
+
+// tempoTracker estimates the clock master's tempo from tick arrival times
+type tempoTracker struct {
+	last      time.Time
+	interval  float64 // Smoothed seconds per tick, 0 until measured
+	published float64
+}
+
+// reset forgets the measurement, e.g. when the master stops
+func (t *tempoTracker) reset() {
+	*t = tempoTracker{}
+}
+
+// tick records a tick and returns the tempo to publish, or 0 if it has not
+// changed enough to be worth passing on
+func (t *tempoTracker) tick(at time.Time) float64 {
+	gap := at.Sub(t.last)
+	t.last = at
+	if gap <= 0 || gap > maxTickGap {
+		return 0
+	}
+
+	if t.interval == 0 {
+		t.interval = gap.Seconds()
+	} else {
+		t.interval += (gap.Seconds() - t.interval) * tempoSmoothing
+	}
+
+	bpm := 60 / (t.interval * audio.ClocksPerQuarter)
+	if math.Abs(bpm-t.published) < minTempoChange {
+		return 0
+	}
+	t.published = bpm
+	return bpm
+}
+
+// runFollow passes incoming MIDI clock to the engine while following is on.
+// Tempo is measured here from arrival times; transport and ticks go to the
+// render loop, which keeps the playhead in phase with them.
+func (s *State) runFollow(msgs <-chan midi.ClockMessage, done <-chan struct{}) {
+	var tempo tempoTracker
+
+	for {
+		var msg midi.ClockMessage
+		select {
+		case <-done:
+			return
+		case m, ok := <-msgs:
+			if !ok {
+				return
+			}
+			msg = m
+		}
+
+		if !s.AudioEngine.Following() {
+			tempo.reset()
+			continue
+		}
+
+		switch msg.Kind {
+		case midi.ClockTick:
+			if bpm := tempo.tick(msg.Time); bpm > 0 {
+				s.AudioEngine.SetExternalTempo(bpm)
+			}
+			s.AudioEngine.ExternalClock(audio.ClockEvent{Kind: audio.ClockTick})
+		case midi.ClockStart:
+			s.AudioEngine.ExternalClock(audio.ClockEvent{Kind: audio.ClockStart})
+		case midi.ClockStop:
+			tempo.reset()
+			s.AudioEngine.ExternalClock(audio.ClockEvent{Kind: audio.ClockStop})
+		case midi.ClockContinue:
+			s.AudioEngine.ExternalClock(audio.ClockEvent{Kind: audio.ClockContinue})
+		case midi.ClockSongPosition:
+			s.AudioEngine.ExternalClock(audio.ClockEvent{Kind: audio.ClockSongPosition, Position: int(msg.Position)})
+		}
+	}
+}
+
+// ToggleFollow turns following of incoming MIDI clock on or off
+func (s *State) ToggleFollow() {
+	s.SetFollow(!s.Following())
+}
+
+// SetFollow makes tempo and transport follow incoming MIDI clock
+func (s *State) SetFollow(enabled bool) {
+	if s.AudioEngine != nil {
+		s.AudioEngine.SetFollow(enabled)
+	}
+}
+
+// Following reports whether tempo and transport follow incoming MIDI clock
+func (s *State) Following() bool {
+	if s.AudioEngine != nil {
+		return s.AudioEngine.Following()
+	}
+	return false
+}
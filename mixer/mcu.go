@@ -0,0 +1,207 @@
+package mixer
+
+import (
+	"fmt"
+
+	"midi-mixer/audio"
+	"midi-mixer/midi"
+)
+
+// mcuSurface is what was last sent to an MCU surface, so feedback only sends
+// changes. Faders at -1 and missing LEDs are sent on the next update.
+type mcuSurface struct {
+	faders [midi.MCUStrips + 1]int
+	leds   map[uint8]bool
+	rings  [midi.MCUStrips]int
+	lcd    [2 * midi.MCUStrips]string // Top row cells, then bottom row
+}
+
+// newMCUSurface returns a surface state that sends everything
+func newMCUSurface() *mcuSurface {
+	m := &mcuSurface{leds: map[uint8]bool{}}
+	for i := range m.faders {
+		m.faders[i] = -1
+	}
+	for i := range m.rings {
+		m.rings[i] = -1
+	}
+	return m
+}
+
+// SetMCU sets whether devices connected from now on are Mackie Control
+// surfaces rather than driven by bindings
+func (s *State) SetMCU(on bool) {
+	s.MidiHandler.SetMCU(on)
+}
+
+// MCUBank returns the first channel shown on the strips of the first
+// connected Mackie Control surface, and false if there is none
+func (s *State) MCUBank() (int, bool) {
+	for _, info := range s.Devices() {
+		if d := s.devices[info.ID]; d != nil && info.MCU {
+			return d.mcuBank, true
+		}
+	}
+	return 0, false
+}
+
+// mcuChannel returns the channel on a strip of a surface, or -1 if the
+// strip is past the last channel
+func (s *State) mcuChannel(d *device, strip int) int {
+	if ch := d.mcuBank + strip; strip < midi.MCUStrips && ch < len(s.Channels) {
+		return ch
+	}
+	return -1
+}
+
+// HandleMCU applies an input from a Mackie Control surface: faders set
+// volume, V-Pots pan (pushing one centers it), strip buttons mute, solo and
+// select, and the bank and channel buttons move the strips over the mixer
+func (s *State) HandleMCU(msg midi.MCUMessage) {
+	d := s.devices[msg.Device]
+	if d == nil {
+		return
+	}
+	if d.mcuSent == nil {
+		d.mcuSent = newMCUSurface()
+	}
+
+	switch msg.Kind {
+	case midi.MCUFader:
+		level := uint16(msg.Value * audio.MaxLevel / midi.MCUFaderMax)
+		if msg.Strip == midi.MCUStrips {
+			s.SetMasterVolume(level)
+		} else if ch := s.mcuChannel(d, msg.Strip); ch >= 0 {
+			s.SetChannelVolume(ch, level)
+		}
+		// The fader is already there
+		d.mcuSent.faders[msg.Strip] = msg.Value
+
+	case midi.MCUTouch:
+		// Feedback holds off while touched, then moves the fader if the mixer
+		// changed meanwhile
+		d.mcuTouched[msg.Strip] = msg.Value > 0
+
+	case midi.MCUVPot:
+		if ch := s.mcuChannel(d, msg.Strip); ch >= 0 {
+			s.SetChannelPan(ch, adjustLevel(s.Channels[ch].Pan, msg.Value))
+		}
+
+	case midi.MCUButton:
+		if msg.Value > 0 {
+			s.pressMCU(d, msg.Button)
+		}
+	}
+}
+
+// pressMCU acts on an MCU button press
+func (s *State) pressMCU(d *device, button uint8) {
+	strip := int(button % midi.MCUStrips)
+	switch {
+	case button >= midi.MCUSolo && button < midi.MCUSolo+midi.MCUStrips:
+		if ch := s.mcuChannel(d, strip); ch >= 0 {
+			s.toggleSolo(&s.Channels[ch])
+		}
+	case button >= midi.MCUMute && button < midi.MCUMute+midi.MCUStrips:
+		if ch := s.mcuChannel(d, strip); ch >= 0 {
+			s.toggleMute(&s.Channels[ch])
+		}
+	case button >= midi.MCUSelect && button < midi.MCUSelect+midi.MCUStrips:
+		if ch := s.mcuChannel(d, strip); ch >= 0 {
+			s.SelectedIndex = ch
+		}
+	case button >= midi.MCUVPotPush && button < midi.MCUVPotPush+midi.MCUStrips:
+		if ch := s.mcuChannel(d, strip); ch >= 0 {
+			s.SetChannelPan(ch, DefaultPan)
+		}
+	case button == midi.MCUBankLeft:
+		s.moveMCUBank(d, -midi.MCUStrips)
+	case button == midi.MCUBankRight:
+		s.moveMCUBank(d, midi.MCUStrips)
+	case button == midi.MCUChannelLeft:
+		s.moveMCUBank(d, -1)
+	case button == midi.MCUChannelRight:
+		s.moveMCUBank(d, 1)
+	case button == midi.MCUPlay:
+		s.TogglePlay()
+	case button == midi.MCUStop:
+		s.Stop()
+	}
+}
+
+// moveMCUBank shifts a surface's strips by delta channels, keeping at least
+// one channel on the surface
+func (s *State) moveMCUBank(d *device, delta int) {
+	d.mcuBank = min(max(d.mcuBank+delta, 0), max(len(s.Channels)-1, 0))
+}
+
+// sendMCUFeedback sends a surface whatever changed since last sent: fader
+// positions (except those being touched), V-Pot rings showing pan, button
+// LEDs and the channel names and pans on the LCD
+func (s *State) sendMCUFeedback(id int, d *device) {
+	if d.mcuSent == nil {
+		d.mcuSent = newMCUSurface()
+	}
+	sent := d.mcuSent
+	h := s.MidiHandler
+
+	for strip := 0; strip <= midi.MCUStrips; strip++ {
+		value := 0
+		if strip == midi.MCUStrips {
+			value = int(s.MasterVolume) * midi.MCUFaderMax / audio.MaxLevel
+		} else if ch := s.mcuChannel(d, strip); ch >= 0 {
+			value = int(s.Channels[ch].Volume) * midi.MCUFaderMax / audio.MaxLevel
+		}
+		if !d.mcuTouched[strip] && sent.faders[strip] != value && h.SendMCUFader(id, strip, value) == nil {
+			sent.faders[strip] = value
+		}
+	}
+
+	leds := map[uint8]bool{
+		midi.MCUPlay: s.Transport() == audio.TransportPlaying,
+		midi.MCUStop: s.Transport() == audio.TransportStopped,
+	}
+	for strip := 0; strip < midi.MCUStrips; strip++ {
+		ch := s.mcuChannel(d, strip)
+		var c Channel
+		if ch >= 0 {
+			c = s.Channels[ch]
+		}
+		leds[midi.MCUMute+uint8(strip)] = ch >= 0 && c.Mute
+		leds[midi.MCUSolo+uint8(strip)] = ch >= 0 && c.Solo
+		leds[midi.MCUSelect+uint8(strip)] = ch >= 0 && ch == s.SelectedIndex
+
+		// Boost/cut fills the ring from the center towards the pan side
+		ring := 0
+		if ch >= 0 {
+			ring = 1 + int(c.Pan)*10/audio.MaxLevel
+		}
+		if sent.rings[strip] != ring && h.SendMCURing(id, strip, midi.MCURingBoostCut, uint8(ring)) == nil {
+			sent.rings[strip] = ring
+		}
+
+		name, pan := "", ""
+		if ch >= 0 {
+			name, pan = c.Name, PanLabel(c.Pan)
+		}
+		s.sendMCUCell(id, d, strip, fmt.Sprintf("%-6.6s ", name))
+		s.sendMCUCell(id, d, midi.MCUStrips+strip, fmt.Sprintf("%-6.6s ", pan))
+	}
+	for button, on := range leds {
+		if was, ok := sent.leds[button]; (!ok || was != on) && h.SendMCULED(id, button, on) == nil {
+			sent.leds[button] = on
+		}
+	}
+}
+
+// sendMCUCell writes one strip's 7 characters of an LCD row if they changed.
+// Cells past the top row's strips are on the bottom row.
+func (s *State) sendMCUCell(id int, d *device, cell int, text string) {
+	if d.mcuSent.lcd[cell] == text {
+		return
+	}
+	offset := cell * 7
+	if s.MidiHandler.SendMCUText(id, offset, text) == nil {
+		d.mcuSent.lcd[cell] = text
+	}
+}
@@ -0,0 +1,250 @@
+package mixer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	gomidi "gitlab.com/gomidi/midi/v2"
+
+	"midi-mixer/audio"
+	"midi-mixer/midi"
+	"midi-mixer/midi/loopback"
+)
+
+// connectLoopback returns an offline mixer with a loopback device connected,
+// as a Mackie Control surface if mcu is set, and the device's ID
+func connectLoopback(t *testing.T, mcu bool) (*State, *loopback.Driver, int) {
+	t.Helper()
+	s := NewOfflineState(8)
+	t.Cleanup(s.Close)
+	s.SetMCU(mcu)
+
+	drv := loopback.New("Test")
+	id, err := s.Connect(drv.In, drv.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, drv, id
+}
+
+// inject sends a message into the loopback input as the controller would
+func inject(t *testing.T, drv *loopback.Driver, msg gomidi.Message) {
+	t.Helper()
+	if err := drv.In.Inject(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receive waits for the next message on a handler channel
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+	panic("unreachable")
+}
+
+// surface is what a Mackie Control surface was sent, decoded from the bytes
+type surface struct {
+	faders map[int]int    // Position by strip
+	leds   map[uint8]bool // LED by button note
+	rings  map[int]uint8  // Ring mode and position by strip
+	lcd    map[int]string // Text by character offset
+}
+
+// decodeSurface decodes the messages sent to a surface
+func decodeSurface(t *testing.T, sent []gomidi.Message) surface {
+	t.Helper()
+	s := surface{faders: map[int]int{}, leds: map[uint8]bool{}, rings: map[int]uint8{}, lcd: map[int]string{}}
+	lcdHeader := string([]byte{0xF0, 0x00, 0x00, 0x66, 0x14, 0x12})
+	for _, msg := range sent {
+		b := []byte(msg)
+		switch {
+		case b[0]&0xF0 == 0xE0:
+			s.faders[int(b[0]&0x0F)] = int(b[1]) | int(b[2])<<7
+		case b[0] == 0x90:
+			s.leds[b[1]] = b[2] == 127
+		case b[0] == 0xB0 && b[1] >= 48 && b[1] < 48+midi.MCUStrips:
+			s.rings[int(b[1]-48)] = b[2]
+		case len(b) > 8 && string(b[:6]) == lcdHeader && b[len(b)-1] == 0xF7:
+			s.lcd[int(b[6])] = string(b[7 : len(b)-1])
+		default:
+			t.Errorf("unexpected message % X sent to the surface", b)
+		}
+	}
+	return s
+}
+
+// checkStrips checks the faders, mute LEDs and LCD names of a surface whose
+// first strip shows channel bank
+func checkStrips(t *testing.T, s *State, got surface, bank int) {
+	t.Helper()
+	for strip := 0; strip < midi.MCUStrips; strip++ {
+		fader, mute, name := 0, false, ""
+		if ch := bank + strip; ch < len(s.Channels) {
+			fader = int(s.Channels[ch].Volume) * midi.MCUFaderMax / audio.MaxLevel
+			mute = s.Channels[ch].Mute
+			name = s.Channels[ch].Name
+		}
+		if got.faders[strip] != fader {
+			t.Errorf("strip %d fader = %d, want %d", strip, got.faders[strip], fader)
+		}
+		if got.leds[midi.MCUMute+uint8(strip)] != mute {
+			t.Errorf("strip %d mute LED = %v, want %v", strip, got.leds[midi.MCUMute+uint8(strip)], mute)
+		}
+		if want := fmt.Sprintf("%-6.6s ", name); got.lcd[strip*7] != want {
+			t.Errorf("strip %d LCD = %q, want %q", strip, got.lcd[strip*7], want)
+		}
+	}
+}
+
+func TestMCUFeedbackOnConnect(t *testing.T) {
+	s, drv, _ := connectLoopback(t, true)
+	got := decodeSurface(t, drv.Out.Sent())
+
+	checkStrips(t, s, got, 0)
+	if want := int(s.MasterVolume) * midi.MCUFaderMax / audio.MaxLevel; got.faders[midi.MCUStrips] != want {
+		t.Errorf("master fader = %d, want %d", got.faders[midi.MCUStrips], want)
+	}
+	if !got.leds[midi.MCUSelect] {
+		t.Error("selected channel's LED is off")
+	}
+	for strip := 0; strip < midi.MCUStrips; strip++ {
+		if got.rings[strip] != midi.MCURingBoostCut|6 {
+			t.Errorf("strip %d ring = %#x, want centered", strip, got.rings[strip])
+		}
+		if got.lcd[(midi.MCUStrips+strip)*7] != "C      " {
+			t.Errorf("strip %d pan = %q, want centered", strip, got.lcd[(midi.MCUStrips+strip)*7])
+		}
+	}
+	if len(got.lcd) != 2*midi.MCUStrips {
+		t.Errorf("sent %d LCD cells, want %d", len(got.lcd), 2*midi.MCUStrips)
+	}
+
+	// Nothing more is sent until something changes
+	s.SendFeedback()
+	if sent := drv.Out.Sent(); len(sent) != 0 {
+		t.Errorf("resent %d messages with nothing changed", len(sent))
+	}
+}
+
+func TestMCUFeedbackAfterBankShift(t *testing.T) {
+	s, drv, _ := connectLoopback(t, true)
+	for ch := range s.Channels {
+		s.SetChannelVolume(ch, uint16(ch*1000))
+	}
+	s.SendFeedback()
+	checkStrips(t, s, decodeSurface(t, drv.Out.Sent()), 0)
+
+	tests := []struct {
+		button uint8
+		bank   int
+	}{
+		{midi.MCUChannelRight, 1},
+		{midi.MCUChannelRight, 2},
+		{midi.MCUBankRight, 7}, // Still one channel on the surface
+		{midi.MCUBankLeft, 0},
+		{midi.MCUChannelLeft, 0},
+	}
+	for _, tt := range tests {
+		inject(t, drv, gomidi.NoteOn(0, tt.button, 127))
+		s.HandleMCU(receive(t, s.MidiHandler.MCUChannel()))
+		inject(t, drv, gomidi.NoteOff(0, tt.button))
+		s.HandleMCU(receive(t, s.MidiHandler.MCUChannel()))
+
+		if bank, _ := s.MCUBank(); bank != tt.bank {
+			t.Fatalf("bank after button %d = %d, want %d", tt.button, bank, tt.bank)
+		}
+		// Only what changed is sent, so check against everything sent so far
+		drv.Out.Sent()
+		resetSurface(s)
+		s.SendFeedback()
+		checkStrips(t, s, decodeSurface(t, drv.Out.Sent()), tt.bank)
+	}
+}
+
+// resetSurface makes the next feedback send every surface everything
+func resetSurface(s *State) {
+	for _, d := range s.devices {
+		d.mcuSent = nil
+	}
+}
+
+func TestMCUBankShiftSendsChanges(t *testing.T) {
+	s, drv, id := connectLoopback(t, true)
+	for ch := range s.Channels {
+		s.SetChannelVolume(ch, uint16(ch*1000))
+	}
+	s.SendFeedback()
+	drv.Out.Sent()
+
+	s.HandleMCU(midi.MCUMessage{Device: id, Kind: midi.MCUButton, Button: midi.MCUChannelRight, Value: 1})
+	s.SendFeedback()
+	got := decodeSurface(t, drv.Out.Sent())
+	for strip := 0; strip < midi.MCUStrips; strip++ {
+		want := (strip + 1) * 1000 * midi.MCUFaderMax / audio.MaxLevel
+		if strip == midi.MCUStrips-1 {
+			want = 0 // Past the last channel
+		}
+		if got.faders[strip] != want {
+			t.Errorf("strip %d fader = %d, want %d", strip, got.faders[strip], want)
+		}
+		if want := fmt.Sprintf("%-6.6s ", channelName(strip+1)); strip < midi.MCUStrips-1 && got.lcd[strip*7] != want {
+			t.Errorf("strip %d LCD = %q, want %q", strip, got.lcd[strip*7], want)
+		}
+	}
+	if _, ok := got.faders[midi.MCUStrips]; ok {
+		t.Error("master fader resent though it didn't change")
+	}
+}
+
+func TestMCUTouchGating(t *testing.T) {
+	tests := []struct {
+		name    string
+		touch   bool // Fader 0 touched before the volume changes
+		release bool // and let go before feedback is sent
+		moved   bool // The volume change comes from the fader itself
+		sent    bool // Fader 0 is sent the new volume
+	}{
+		{name: "untouched", sent: true},
+		{name: "touched", touch: true},
+		{name: "let go", touch: true, release: true, sent: true},
+		{name: "moved by hand", touch: true, moved: true},
+		{name: "moved and let go", touch: true, moved: true, release: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, drv, id := connectLoopback(t, true)
+			drv.Out.Sent()
+
+			if tt.touch {
+				s.HandleMCU(midi.MCUMessage{Device: id, Kind: midi.MCUTouch, Strip: 0, Value: 1})
+			}
+			if tt.moved {
+				s.HandleMCU(midi.MCUMessage{Device: id, Kind: midi.MCUFader, Strip: 0, Value: 4000})
+				if want := uint16(4000 * audio.MaxLevel / midi.MCUFaderMax); s.Channels[0].Volume != want {
+					t.Errorf("volume = %d, want %d", s.Channels[0].Volume, want)
+				}
+			} else {
+				s.SetChannelVolume(0, 4000)
+			}
+			s.SendFeedback()
+			if tt.release {
+				s.HandleMCU(midi.MCUMessage{Device: id, Kind: midi.MCUTouch, Strip: 0, Value: 0})
+				s.SendFeedback()
+			}
+
+			got := decodeSurface(t, drv.Out.Sent())
+			if _, sent := got.faders[0]; sent != tt.sent {
+				t.Errorf("fader sent = %v, want %v", sent, tt.sent)
+			}
+			if len(got.faders) > 1 {
+				t.Errorf("sent %d faders, want only the changed one", len(got.faders))
+			}
+		})
+	}
+}
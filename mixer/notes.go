@@ -0,0 +1,130 @@
+package mixer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"midi-mixer/audio"
+	"midi-mixer/midi"
+)
+
+// DefaultNoteMap plays the drums from the General MIDI drum map (36 kick,
+// 38/40 snare, 42/44/46 hats), the bass from the octave below them and the
+// leads from the two octaves above
+const DefaultNoteMap = "bass=24-35,kick=36,snare=38,snare=40,hihat=42,hihat=44,hihat=46,lead1=48-71,lead2=72-96"
+
+// NoteMap assigns incoming MIDI note numbers to mixer channels
+type NoteMap map[uint8]int
+
+// ParseNoteMap reads a note map from a comma-separated list of
+// channel=note or channel=low-high entries, such as "kick=36,bass=24-47".
+// Channels are named as on the mixer strips; later entries win.
+func ParseNoteMap(spec string) (NoteMap, error) {
+	notes := NoteMap{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, span, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("note map entry %q: want channel=note or channel=low-high", entry)
+		}
+		ch := channelIndex(name)
+		if ch < 0 {
+			return nil, fmt.Errorf("note map entry %q: unknown channel %q", entry, name)
+		}
+
+		lowStr, highStr, isRange := strings.Cut(span, "-")
+		if !isRange {
+			highStr = lowStr
+		}
+		low, err := parseNote(lowStr)
+		if err != nil {
+			return nil, fmt.Errorf("note map entry %q: %w", entry, err)
+		}
+		high, err := parseNote(highStr)
+		if err != nil {
+			return nil, fmt.Errorf("note map entry %q: %w", entry, err)
+		}
+		if low > high {
+			return nil, fmt.Errorf("note map entry %q: range is backwards", entry)
+		}
+		for n := low; n <= high; n++ {
+			notes[uint8(n)] = ch
+		}
+	}
+	return notes, nil
+}
+
+// parseNote reads a MIDI note number
+func parseNote(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 || n > 127 {
+		return 0, fmt.Errorf("note %q is not a number from 0 to 127", s)
+	}
+	return n, nil
+}
+
+// channelIndex returns the channel with the given strip name, or -1
+func channelIndex(name string) int {
+	for i, n := range audio.ChannelNames {
+		if strings.EqualFold(n, strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetNoteMap replaces the mapping of incoming notes to channels
+func (s *State) SetNoteMap(notes NoteMap) {
+	s.noteMap.Store(&notes)
+}
+
+// runNotes plays incoming MIDI notes on the channels the note map assigns
+// them to, except notes that bindings use as buttons and, in learn mode,
+// notes played to be learned. Notes go straight to the engine rather than
+// through the UI loop, so finger drumming stays tight while the screen
+// redraws.
+func (s *State) runNotes(msgs <-chan midi.NoteMessage, done <-chan struct{}) {
+	for {
+		var msg midi.NoteMessage
+		select {
+		case <-done:
+			return
+		case m, ok := <-msgs:
+			if !ok {
+				return
+			}
+			msg = m
+		}
+
+		// Notes bound to mixer controls, such as pad buttons, go to the UI,
+		// as do notes pressed to learn them
+		learn := s.learningNotes.Load() && msg.Velocity > 0
+		if bound := s.boundNotes.Load(); learn || bound != nil && (*bound)[boundNote{msg.Device, msg.Channel, msg.Key}] {
+			select {
+			case s.boundNoteChan <- msg:
+			default:
+				// UI behind, drop the note
+				s.droppedNotes.Add(1)
+			}
+			continue
+		}
+
+		notes := s.noteMap.Load()
+		if notes == nil {
+			continue
+		}
+		ch, ok := (*notes)[msg.Key]
+		if !ok {
+			continue
+		}
+		if msg.Velocity == 0 {
+			s.AudioEngine.NoteOff(ch, int(msg.Key))
+		} else {
+			s.AudioEngine.NoteOn(ch, int(msg.Key), int(msg.Velocity))
+		}
+	}
+}
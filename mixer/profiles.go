@@ -0,0 +1,285 @@
+package mixer
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"midi-mixer/jsonfile"
+	"midi-mixer/midi"
+)
+
+// Profile is a named set of controller bindings, usually describing one
+// model of controller. The active bindings are saved in the same format, so
+// a learned setup can be copied into the profile directory as it is.
+type Profile struct {
+	ID          string // File name without extension
+	Name        string
+	Description string
+	Bindings    []Binding
+}
+
+// profileFile is the JSON layout of a profile or bindings file. MIDI
+// channels and strips count from 1, as on hardware and on the mixer screen.
+// A bindings file also holds the bindings of devices with their own, by
+// device name; profiles ignore them.
+type profileFile struct {
+	Name        string                 `json:"name,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Bindings    []bindingEntry         `json:"bindings"`
+	Devices     map[string]profileFile `json:"devices,omitempty"`
+}
+
+// bindingEntry is one binding in a profile file, with one of a cc, a cc14
+// (the MSB's CC number of a 14-bit pair), an nrpn or a note
+type bindingEntry struct {
+	Channel int    `json:"channel"`
+	CC      *int   `json:"cc,omitempty"`
+	CC14    *int   `json:"cc14,omitempty"`
+	NRPN    *int   `json:"nrpn,omitempty"`
+	Note    *int   `json:"note,omitempty"`
+	Target  string `json:"target"`
+	Strip   int    `json:"strip,omitempty"`
+}
+
+// control returns the entry's control number, its largest valid value and
+// how many kinds of control it gives
+func (entry bindingEntry) control() (number *int, limit int, given int) {
+	for _, c := range []struct {
+		number *int
+		limit  int
+	}{{entry.CC, 127}, {entry.CC14, int(midi.CCLSBOffset) - 1}, {entry.NRPN, midi.Max14}, {entry.Note, 127}} {
+		if c.number != nil {
+			number, limit = c.number, c.limit
+			given++
+		}
+	}
+	return number, limit, given
+}
+
+// parseProfile decodes and validates a profile file. Strips beyond the
+// mixer's channels are allowed and ignored, so profiles for wider
+// controllers still load.
+func parseProfile(data []byte) (Profile, error) {
+	var file profileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Profile{}, err
+	}
+	return file.profile()
+}
+
+// parseBindings decodes and validates a bindings file into the default
+// bindings and those of devices with their own
+func parseBindings(data []byte) (Profile, map[string]Profile, error) {
+	var file profileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Profile{}, nil, err
+	}
+	p, err := file.profile()
+	if err != nil {
+		return p, nil, err
+	}
+
+	devices := map[string]Profile{}
+	for name, f := range file.Devices {
+		dp, err := f.profile()
+		if err != nil {
+			return p, nil, fmt.Errorf("device %s: %w", name, err)
+		}
+		devices[name] = dp
+	}
+	return p, devices, nil
+}
+
+// profile validates the file's bindings
+func (file profileFile) profile() (Profile, error) {
+	p := Profile{Name: file.Name, Description: file.Description, Bindings: []Binding{}}
+	for _, entry := range file.Bindings {
+		kind := TargetKind(-1)
+		for k, key := range targetKeys {
+			if key == entry.Target {
+				kind = TargetKind(k)
+			}
+		}
+
+		number, limit, given := entry.control()
+		switch {
+		case kind < 0:
+			return p, fmt.Errorf("unknown target %q", entry.Target)
+		case entry.Channel < 1 || entry.Channel > 16:
+			return p, fmt.Errorf("MIDI channel must be 1-16, got %d", entry.Channel)
+		case given != 1:
+			return p, fmt.Errorf("%s binding needs one of a cc, cc14, nrpn or note", entry.Target)
+		case *number < 0 || *number > limit:
+			return p, fmt.Errorf("%s binding number must be 0-%d, got %d", entry.Target, limit, *number)
+		case kind.PerChannel() && entry.Strip < 1:
+			return p, fmt.Errorf("%s binding needs a strip number", entry.Target)
+		}
+
+		target := Target{Kind: kind}
+		if kind.PerChannel() {
+			target.Channel = entry.Strip - 1
+		}
+		b := Binding{
+			MIDIChannel: uint8(entry.Channel - 1),
+			Note:        entry.Note != nil,
+			HighRes:     entry.CC14 != nil,
+			NRPN:        entry.NRPN != nil,
+			Target:      target,
+		}
+		if b.NRPN {
+			b.Parameter = uint16(*number)
+		} else {
+			b.Controller = uint8(*number)
+		}
+		p.Bindings = append(p.Bindings, b)
+	}
+	return p, nil
+}
+
+// marshal encodes the profile in the profile file format
+func (p Profile) marshal() ([]byte, error) {
+	return marshalFile(p.file())
+}
+
+// marshalBindings encodes the default bindings and those of devices with
+// their own in the bindings file format
+func marshalBindings(p Profile, devices map[string]Profile) ([]byte, error) {
+	file := p.file()
+	if len(devices) > 0 {
+		file.Devices = map[string]profileFile{}
+		for name, dp := range devices {
+			file.Devices[name] = dp.file()
+		}
+	}
+	return marshalFile(file)
+}
+
+// file converts the profile to its JSON layout
+func (p Profile) file() profileFile {
+	file := profileFile{Name: p.Name, Description: p.Description, Bindings: []bindingEntry{}}
+	for _, b := range p.Bindings {
+		number := int(b.Controller)
+		entry := bindingEntry{
+			Channel: int(b.MIDIChannel) + 1,
+			Target:  targetKeys[b.Target.Kind],
+		}
+		switch {
+		case b.Note:
+			entry.Note = &number
+		case b.NRPN:
+			parameter := int(b.Parameter)
+			entry.NRPN = &parameter
+		case b.HighRes:
+			entry.CC14 = &number
+		default:
+			entry.CC = &number
+		}
+		if b.Target.Kind.PerChannel() {
+			entry.Strip = b.Target.Channel + 1
+		}
+		file.Bindings = append(file.Bindings, entry)
+	}
+	return file
+}
+
+// marshalFile encodes a profile file with one binding per line
+func marshalFile(file profileFile) ([]byte, error) {
+	return jsonfile.Marshal(file, bindingObject)
+}
+
+// bindingObject matches a binding spread over several lines, which marshal
+// puts back on one line so profiles stay readable and diff cleanly
+var bindingObject = regexp.MustCompile(`\{\s+"channel"[^{}]*\}`)
+
+// Bundled profiles for common controllers
+//
+//go:embed profiles/*.json
+var builtinProfiles embed.FS
+
+// parseBuiltinProfiles decodes the embedded profiles once
+var parseBuiltinProfiles = sync.OnceValue(func() []Profile {
+	profiles, err := jsonfile.ReadAll(builtinProfiles, "profiles", "profile", readProfile)
+	if err != nil || len(profiles) == 0 {
+		panic(fmt.Sprintf("mixer: bundled profiles are broken: %v", err))
+	}
+	return profiles
+})
+
+// DefaultProfiles returns the bundled controller profiles. The first one is
+// the generic layout used until something else is chosen.
+func DefaultProfiles() []Profile {
+	return append([]Profile(nil), parseBuiltinProfiles()...)
+}
+
+// LoadProfiles returns the bundled profiles extended with the *.json
+// profiles in dir. A profile whose file name matches a bundled one replaces
+// it; the rest are appended in file name order. A missing dir is not an error.
+func LoadProfiles(dir string) ([]Profile, error) {
+	return jsonfile.Extend(DefaultProfiles(), dir, "profile", readProfile, func(p Profile) string {
+		return p.ID
+	})
+}
+
+// readProfile decodes and validates a profile file named id, which is also
+// its name unless it gives one
+func readProfile(data []byte, id string) (Profile, error) {
+	p, err := parseProfile(data)
+	p.ID = id
+	if p.Name == "" {
+		p.Name = id
+	}
+	return p, err
+}
+
+// LoadProfiles replaces the profile list with the bundled profiles and
+// those in dir. Broken files are skipped and reported.
+func (s *State) LoadProfiles(dir string) error {
+	profiles, err := LoadProfiles(dir)
+	s.Profiles = profiles
+	return err
+}
+
+// UseProfile gives a device its own copy of a profile's bindings, in place
+// of the default ones, and saves them so the choice is remembered between
+// runs
+func (s *State) UseProfile(id, index int) error {
+	d := s.devices[id]
+	if d == nil || index < 0 || index >= len(s.Profiles) {
+		return nil
+	}
+	p := s.Profiles[index]
+	p.Bindings = append([]Binding(nil), p.Bindings...)
+	s.DeviceBindings[d.name] = p
+	d.feedbackSent = nil
+	s.updateBoundNotes()
+	return s.saveBindings()
+}
+
+// UseDefaultBindings drops a device's own bindings so it goes back to the
+// default ones, and saves the change
+func (s *State) UseDefaultBindings(id int) error {
+	d := s.devices[id]
+	if d == nil {
+		return nil
+	}
+	delete(s.DeviceBindings, d.name)
+	d.feedbackSent = nil
+	s.updateBoundNotes()
+	return s.saveBindings()
+}
+
+// ProfileIndex returns the position in the profile list of the profile the
+// bindings of a device come from, by device name, or -1 if they don't come
+// from one. The name "" gives the default bindings' profile.
+func (s *State) ProfileIndex(name string) int {
+	bindings := s.bindingsProfile(name)
+	for i, p := range s.Profiles {
+		if p.Name == bindings.Name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,57 @@
+package mixer
+
+import (
+	"fmt"
+
+	"midi-mixer/midi"
+)
+
+// programsPerBank is how many patterns one Bank Select covers, a Program
+// Change choosing among them
+const programsPerBank = 128
+
+// SetProgramChannel sets the MIDI channel, 0-15, whose Program Changes
+// select patterns and on which pattern changes are sent, or -1 for none
+func (s *State) SetProgramChannel(channel int) {
+	s.programChannel = channel
+}
+
+// HandleProgram selects the pattern a Program Change on the program channel
+// picks: program 0-127 of bank 0 are the first 128 patterns, bank 1 the
+// next 128 and so on. A program beyond the patterns is ignored, returning
+// an error saying so.
+func (s *State) HandleProgram(msg midi.ProgramMessage) error {
+	if s.programChannel < 0 || int(msg.Channel) != s.programChannel || s.AudioEngine == nil {
+		return nil
+	}
+	index := int(msg.Bank)*programsPerBank + int(msg.Program)
+	if count := s.AudioEngine.PatternCount(); index >= count {
+		return fmt.Errorf("ignored bank %d program %d: pattern %d of %d", msg.Bank, msg.Program, index+1, count)
+	}
+	s.SetPattern(index)
+	// The device knows which pattern it picked
+	if d := s.devices[msg.Device]; d != nil {
+		d.programSent = index
+	}
+	return nil
+}
+
+// sendProgram sends a device a Program Change for the current pattern if
+// the pattern changed since last sent, preceded by Bank Select when there
+// are more patterns than one bank holds
+func (s *State) sendProgram(id int, d *device) {
+	index := s.GetPatternIndex()
+	if s.programChannel < 0 || d.programSent == index {
+		return
+	}
+
+	channel := uint8(s.programChannel)
+	if s.AudioEngine != nil && s.AudioEngine.PatternCount() > programsPerBank {
+		if err := s.MidiHandler.SendBankSelect(id, channel, uint16(index/programsPerBank)); err != nil {
+			return
+		}
+	}
+	if err := s.MidiHandler.SendProgram(id, channel, uint8(index%programsPerBank)); err == nil {
+		d.programSent = index
+	}
+}
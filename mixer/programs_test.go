@@ -0,0 +1,138 @@
+package mixer
+
+import (
+	"fmt"
+	"testing"
+
+	gomidi "gitlab.com/gomidi/midi/v2"
+
+	"midi-mixer/audio"
+	"midi-mixer/midi"
+	"midi-mixer/midi/loopback"
+)
+
+// manyPatterns gives the mixer n patterns, more than one bank holds
+func manyPatterns(s *State, n int) {
+	base := audio.DefaultPatterns()[0]
+	patterns := make([]audio.BeatPreset, n)
+	for i := range patterns {
+		patterns[i] = base
+		patterns[i].ID = fmt.Sprintf("pattern-%d", i)
+	}
+	s.AudioEngine.SetPatterns(patterns)
+}
+
+// controllerProgram sends a Program Change from the loopback controller
+// through the mixer, returning what the mixer made of it
+func controllerProgram(t *testing.T, s *State, drv *loopback.Driver, msgs ...gomidi.Message) error {
+	t.Helper()
+	for _, msg := range msgs {
+		inject(t, drv, msg)
+	}
+	return s.HandleProgram(receive(t, s.MidiHandler.ProgramChannel()))
+}
+
+func TestProgramSelectsPattern(t *testing.T) {
+	s, drv, _ := connectLoopback(t, false)
+	manyPatterns(s, 300)
+	s.SetProgramChannel(9)
+
+	controllerProgram(t, s, drv, gomidi.ProgramChange(9, 5))
+	if got := s.GetPatternIndex(); got != 5 {
+		t.Errorf("program 5 selected pattern %d", got)
+	}
+
+	// Bank Select MSB and LSB make a 14-bit bank of 128 programs each
+	controllerProgram(t, s, drv,
+		gomidi.ControlChange(9, midi.CCBankSelect, 0),
+		gomidi.ControlChange(9, midi.CCBankSelectLSB, 2),
+		gomidi.ProgramChange(9, 3),
+	)
+	if got := s.GetPatternIndex(); got != 2*128+3 {
+		t.Errorf("bank 2 program 3 selected pattern %d, want %d", got, 2*128+3)
+	}
+
+	// Programs past the last pattern, or on another channel, are ignored
+	if err := controllerProgram(t, s, drv, gomidi.ProgramChange(9, 100)); err == nil {
+		t.Error("no error for program past the last pattern")
+	}
+	controllerProgram(t, s, drv, gomidi.ControlChange(9, midi.CCBankSelectLSB, 0), gomidi.ProgramChange(8, 7))
+	if got := s.GetPatternIndex(); got != 2*128+3 {
+		t.Errorf("pattern %d selected, want %d unchanged", got, 2*128+3)
+	}
+}
+
+func TestProgramBankMSBOnly(t *testing.T) {
+	// Devices sending only Bank Select MSB count banks by it alone
+	s, drv, _ := connectLoopback(t, false)
+	manyPatterns(s, 300)
+	s.SetProgramChannel(9)
+
+	if err := controllerProgram(t, s, drv,
+		gomidi.ControlChange(9, midi.CCBankSelect, 1),
+		gomidi.ProgramChange(9, 4),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetPatternIndex(); got != 128+4 {
+		t.Errorf("MSB 1 program 4 selected pattern %d, want %d", got, 128+4)
+	}
+
+	// With an LSB the MSB becomes the high 7 bits, past the last pattern here
+	err := controllerProgram(t, s, drv,
+		gomidi.ControlChange(9, midi.CCBankSelect, 1),
+		gomidi.ControlChange(9, midi.CCBankSelectLSB, 0),
+		gomidi.ProgramChange(9, 5),
+	)
+	if err == nil {
+		t.Error("no error for bank 128")
+	}
+
+	// A new MSB drops the earlier LSB
+	if err := controllerProgram(t, s, drv,
+		gomidi.ControlChange(9, midi.CCBankSelect, 2),
+		gomidi.ProgramChange(9, 6),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetPatternIndex(); got != 2*128+6 {
+		t.Errorf("MSB 2 program 6 selected pattern %d, want %d", got, 2*128+6)
+	}
+}
+
+func TestProgramSent(t *testing.T) {
+	s, drv, _ := connectLoopback(t, false)
+	manyPatterns(s, 300)
+	s.SetProgramChannel(9)
+	s.SendFeedback()
+	drv.Out.Sent()
+
+	s.SetPattern(130)
+	s.SendFeedback()
+	checkSent(t, drv,
+		gomidi.ControlChange(9, midi.CCBankSelect, 0),
+		gomidi.ControlChange(9, midi.CCBankSelectLSB, 1),
+		gomidi.ProgramChange(9, 2),
+	)
+
+	// A pattern picked by the device isn't sent back to it
+	controllerProgram(t, s, drv, gomidi.ProgramChange(9, 4))
+	s.SendFeedback()
+	checkSent(t, drv)
+}
+
+func TestNoProgramChannel(t *testing.T) {
+	// Program Change is off unless a channel is chosen
+	s, drv, _ := connectLoopback(t, false)
+	s.SendFeedback()
+	drv.Out.Sent()
+
+	controllerProgram(t, s, drv, gomidi.ProgramChange(0, 3))
+	if got := s.GetPatternIndex(); got != 0 {
+		t.Errorf("program 3 selected pattern %d with no program channel", got)
+	}
+
+	s.SetPattern(2)
+	s.SendFeedback()
+	checkSent(t, drv)
+}
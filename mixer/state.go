@@ -1,6 +1,11 @@
 package mixer
 
 import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
 	"midi-mixer/audio"
 	"midi-mixer/midi"
 )
@@ -9,37 +14,134 @@ import (
 type Channel struct {
 	ID     int
 	Name   string
-	Volume uint8 // 0-127, mapped to CC7
-	Pan    uint8 // 0-127 (64=center), mapped to CC10
+	Volume uint16 // 0-audio.MaxLevel, 14-bit so high-resolution faders aren't stepped
+	Pan    uint16 // 0-audio.MaxLevel (audio.LevelCenter=center)
 	Mute   bool
 	Solo   bool
 }
 
+// Default levels of a new channel and the master
+const (
+	DefaultVolume = 12872 // 100 on a 7-bit fader, ~79%
+	DefaultPan    = audio.LevelCenter
+)
+
 // NewChannel creates a new mixer channel with default values
 func NewChannel(id int, name string) Channel {
 	return Channel{
 		ID:     id,
 		Name:   name,
-		Volume: 100, // ~79% default
-		Pan:    64,  // Center
+		Volume: DefaultVolume,
+		Pan:    DefaultPan,
 		Mute:   false,
 		Solo:   false,
 	}
 }
 
+// levelStep is one 7-bit step of a 14-bit level, the unit of key and V-Pot
+// adjustments
+const levelStep = 128
+
+// from7 scales a 7-bit controller value to a 14-bit level, keeping 64 at
+// the center and 127 at the top
+func from7(value uint8) uint16 {
+	if value <= 64 {
+		return uint16(value) * levelStep
+	}
+	return audio.LevelCenter + uint16((int(value)-64)*(audio.MaxLevel-audio.LevelCenter)/63)
+}
+
+// to7 is the inverse of from7, rounding to the nearest 7-bit value
+func to7(level uint16) uint8 {
+	if level <= audio.LevelCenter {
+		return uint8((int(level) + levelStep/2) / levelStep)
+	}
+	const top = audio.MaxLevel - audio.LevelCenter
+	return 64 + uint8(((int(level)-audio.LevelCenter)*63+top/2)/top)
+}
+
+// adjustLevel moves a level by delta 7-bit steps, staying in range
+func adjustLevel(level uint16, delta int) uint16 {
+	return uint16(min(max(int(level)+delta*levelStep, 0), audio.MaxLevel))
+}
+
+// PanLabel describes a pan position for the mixer screen and MCU LCD, e.g.
+// "L50". Within 10 steps of a 7-bit knob from center reads as center.
+func PanLabel(pan uint16) string {
+	const center, dead = audio.LevelCenter, 10 * levelStep
+	switch {
+	case pan < center-dead:
+		return fmt.Sprintf("L%d", (center-int(pan))*100/center)
+	case pan > center+dead:
+		return fmt.Sprintf("R%d", (int(pan)-center)*100/(audio.MaxLevel-center))
+	}
+	return "C"
+}
+
 // State holds the complete mixer state
 type State struct {
 	Channels      []Channel
-	MasterVolume  uint8
+	MasterVolume  uint16 // 0-audio.MaxLevel
 	SelectedIndex int
 	MidiHandler   *midi.Handler
 	AudioEngine   *audio.Engine
+	AudioErr      error // Set when the requested audio output failed to start
+	PatternDir    string
 	InputPortIdx  int
 	OutputPortIdx int
+
+	// Step sequencer edit mode and cursor position
+	EditMode bool
+	EditRow  int
+	EditStep int
+
+	// MIDI controller bindings, the profiles they can come from and learn mode.
+	// Bindings are the default ones, used by devices without their own.
+	Bindings       []Binding
+	BindingsName   string // Name of the profile the bindings came from
+	BindingsPath   string
+	DeviceBindings map[string]Profile // Bindings of devices with their own, by device name
+	Profiles       []Profile
+	Learning       bool
+	LearnKind      TargetKind
+
+	devices        map[int]*device                    // Connected MIDI devices by ID
+	lostDevices    []midi.Device                      // Devices whose ports went away
+	learnDevice    string                             // Device last used, whose bindings learn mode shows
+	learnedMSB     *learnedMSB                        // CC just learned, until its LSB may have followed
+	programChannel int                                // MIDI channel of pattern Program Changes, or -1
+	noteMap        atomic.Pointer[NoteMap]            // Read by the note goroutine
+	boundNotes     atomic.Pointer[map[boundNote]bool] // Notes the note goroutine leaves to bindings
+	boundNoteChan  chan midi.NoteMessage
+	learningNotes  atomic.Bool   // Learning, read by the note goroutine
+	droppedNotes   atomic.Uint64 // Bound notes dropped with the UI behind
+	done           chan struct{} // Closed on Close to stop background goroutines
 }
 
-// NewState creates a new mixer state with 8 channels
-func NewState(numChannels int) *State {
+// NewState creates a new mixer state playing through the given sink. If the
+// sink cannot be started the engine falls back to a null sink so the
+// sequencer keeps running, and the failure is reported in AudioErr.
+func NewState(numChannels int, sink audio.Sink) *State {
+	// Initialize audio engine
+	audioEngine, err := audio.NewEngine(numChannels, sink)
+	if err != nil {
+		err = fmt.Errorf("audio output %s unavailable, running silent: %w", sink, err)
+		audioEngine, _ = audio.NewEngine(numChannels, audio.NewNullSink())
+	}
+
+	state := newState(numChannels, audioEngine)
+	state.AudioErr = err
+	return state
+}
+
+// NewOfflineState creates a mixer state whose audio engine has no output,
+// for rendering the mix to a file without a sound card
+func NewOfflineState(numChannels int) *State {
+	return newState(numChannels, audio.NewOfflineEngine(numChannels))
+}
+
+// newState sets up the channel strips and syncs them to the audio engine
+func newState(numChannels int, audioEngine *audio.Engine) *State {
 	channels := make([]Channel, numChannels)
 	for i := 0; i < numChannels; i++ {
 		channels[i] = NewChannel(i, channelName(i))
@@ -50,18 +152,25 @@ func NewState(numChannels int) *State {
 		channels[audio.ChFX].Mute = true
 	}
 
-	// Initialize audio engine
-	audioEngine, _ := audio.NewEngine(numChannels)
-
 	state := &State{
-		Channels:      channels,
-		MasterVolume:  100,
-		SelectedIndex: 0,
-		MidiHandler:   midi.NewHandler(),
-		AudioEngine:   audioEngine,
-		InputPortIdx:  -1,
-		OutputPortIdx: -1,
-	}
+		Channels:       channels,
+		MasterVolume:   DefaultVolume,
+		SelectedIndex:  0,
+		MidiHandler:    midi.NewHandler(),
+		AudioEngine:    audioEngine,
+		InputPortIdx:   -1,
+		OutputPortIdx:  -1,
+		programChannel: -1,
+		Profiles:       DefaultProfiles(),
+		DeviceBindings: map[string]Profile{},
+		devices:        map[int]*device{},
+		boundNoteChan:  make(chan midi.NoteMessage, 64),
+		done:           make(chan struct{}),
+	}
+
+	state.setBindings(state.Profiles[0])
+	notes, _ := ParseNoteMap(DefaultNoteMap)
+	state.SetNoteMap(notes)
 
 	// Sync initial state to audio engine
 	if audioEngine != nil {
@@ -71,6 +180,9 @@ func NewState(numChannels int) *State {
 			audioEngine.SetChannelMute(i, ch.Mute)
 		}
 		audioEngine.SetMasterVolume(state.MasterVolume)
+		go state.runClock(audioEngine.ClockEvents(), state.done)
+		go state.runFollow(state.MidiHandler.ClockChannel(), state.done)
+		go state.runNotes(state.MidiHandler.NoteChannel(), state.done)
 	}
 
 	return state
@@ -106,89 +218,62 @@ func (s *State) SelectPrev() {
 	}
 }
 
-// AdjustVolume changes the selected channel's volume
+// AdjustVolume changes the selected channel's volume by delta 7-bit steps
 func (s *State) AdjustVolume(delta int) {
 	ch := s.SelectedChannel()
 	if ch == nil {
 		return
 	}
 
-	newVal := int(ch.Volume) + delta
-	if newVal < 0 {
-		newVal = 0
-	} else if newVal > 127 {
-		newVal = 127
-	}
-	ch.Volume = uint8(newVal)
+	ch.Volume = adjustLevel(ch.Volume, delta)
 
 	// Update audio engine
 	if s.AudioEngine != nil {
 		s.AudioEngine.SetChannelVolume(ch.ID, ch.Volume)
 	}
-
-	// Send MIDI CC if not muted
-	if !ch.Mute && s.MidiHandler != nil {
-		s.MidiHandler.SendCC(uint8(ch.ID), midi.CCVolume, ch.Volume)
-	}
 }
 
-// AdjustPan changes the selected channel's pan
+// AdjustPan changes the selected channel's pan by delta 7-bit steps
 func (s *State) AdjustPan(delta int) {
 	ch := s.SelectedChannel()
 	if ch == nil {
 		return
 	}
 
-	newVal := int(ch.Pan) + delta
-	if newVal < 0 {
-		newVal = 0
-	} else if newVal > 127 {
-		newVal = 127
-	}
-	ch.Pan = uint8(newVal)
+	ch.Pan = adjustLevel(ch.Pan, delta)
 
 	// Update audio engine
 	if s.AudioEngine != nil {
 		s.AudioEngine.SetChannelPan(ch.ID, ch.Pan)
 	}
-
-	// Send MIDI CC
-	if s.MidiHandler != nil {
-		s.MidiHandler.SendCC(uint8(ch.ID), midi.CCPan, ch.Pan)
-	}
 }
 
 // ToggleMute toggles mute on the selected channel
 func (s *State) ToggleMute() {
-	ch := s.SelectedChannel()
-	if ch == nil {
-		return
+	if ch := s.SelectedChannel(); ch != nil {
+		s.toggleMute(ch)
 	}
+}
 
+// toggleMute toggles mute on a channel
+func (s *State) toggleMute(ch *Channel) {
 	ch.Mute = !ch.Mute
 
 	// Update audio engine
 	if s.AudioEngine != nil {
 		s.AudioEngine.SetChannelMute(ch.ID, ch.Mute)
 	}
-
-	// Send volume 0 when muted, restore when unmuted
-	if s.MidiHandler != nil {
-		if ch.Mute {
-			s.MidiHandler.SendCC(uint8(ch.ID), midi.CCVolume, 0)
-		} else {
-			s.MidiHandler.SendCC(uint8(ch.ID), midi.CCVolume, ch.Volume)
-		}
-	}
 }
 
 // ToggleSolo toggles solo on the selected channel
 func (s *State) ToggleSolo() {
-	ch := s.SelectedChannel()
-	if ch == nil {
-		return
+	if ch := s.SelectedChannel(); ch != nil {
+		s.toggleSolo(ch)
 	}
+}
 
+// toggleSolo toggles solo on a channel
+func (s *State) toggleSolo(ch *Channel) {
 	ch.Solo = !ch.Solo
 
 	// Update audio engine for all channels
@@ -197,45 +282,10 @@ func (s *State) ToggleSolo() {
 			s.AudioEngine.SetChannelSolo(c.ID, c.Solo)
 		}
 	}
-
-	s.updateSoloState()
-}
-
-// updateSoloState handles solo logic (mutes non-soloed channels when any solo is active)
-func (s *State) updateSoloState() {
-	// Check if any channel is soloed
-	anySolo := false
-	for _, ch := range s.Channels {
-		if ch.Solo {
-			anySolo = true
-			break
-		}
-	}
-
-	if s.MidiHandler == nil {
-		return
-	}
-
-	// Update MIDI output based on solo state
-	for _, ch := range s.Channels {
-		var volume uint8 = 0
-		if !anySolo {
-			// No solo active, use normal mute logic
-			if !ch.Mute {
-				volume = ch.Volume
-			}
-		} else {
-			// Solo active, only soloed channels are audible
-			if ch.Solo && !ch.Mute {
-				volume = ch.Volume
-			}
-		}
-		s.MidiHandler.SendCC(uint8(ch.ID), midi.CCVolume, volume)
-	}
 }
 
 // SetChannelVolume sets volume for a specific channel (used for incoming MIDI)
-func (s *State) SetChannelVolume(channelID int, value uint8) {
+func (s *State) SetChannelVolume(channelID int, value uint16) {
 	if channelID >= 0 && channelID < len(s.Channels) {
 		s.Channels[channelID].Volume = value
 		if s.AudioEngine != nil {
@@ -245,7 +295,7 @@ func (s *State) SetChannelVolume(channelID int, value uint8) {
 }
 
 // SetChannelPan sets pan for a specific channel (used for incoming MIDI)
-func (s *State) SetChannelPan(channelID int, value uint8) {
+func (s *State) SetChannelPan(channelID int, value uint16) {
 	if channelID >= 0 && channelID < len(s.Channels) {
 		s.Channels[channelID].Pan = value
 		if s.AudioEngine != nil {
@@ -254,15 +304,14 @@ func (s *State) SetChannelPan(channelID int, value uint8) {
 	}
 }
 
-// AdjustMasterVolume changes the master volume
+// AdjustMasterVolume changes the master volume by delta 7-bit steps
 func (s *State) AdjustMasterVolume(delta int) {
-	newVal := int(s.MasterVolume) + delta
-	if newVal < 0 {
-		newVal = 0
-	} else if newVal > 127 {
-		newVal = 127
-	}
-	s.MasterVolume = uint8(newVal)
+	s.SetMasterVolume(adjustLevel(s.MasterVolume, delta))
+}
+
+// SetMasterVolume sets the master volume (used for incoming MIDI)
+func (s *State) SetMasterVolume(value uint16) {
+	s.MasterVolume = value
 
 	// Update audio engine
 	if s.AudioEngine != nil {
@@ -270,14 +319,30 @@ func (s *State) AdjustMasterVolume(delta int) {
 	}
 }
 
-// AdjustBPM changes the tempo
+// AdjustBPM changes the tempo. The clock master owns the tempo while
+// following an external clock.
 func (s *State) AdjustBPM(delta int) {
-	if s.AudioEngine != nil {
+	if s.AudioEngine != nil && !s.AudioEngine.Following() {
 		newBPM := s.AudioEngine.GetBPM() + delta
 		s.AudioEngine.SetBPM(newBPM)
 	}
 }
 
+// AdjustSwing changes the swing of every pattern
+func (s *State) AdjustSwing(delta int) {
+	if s.AudioEngine != nil {
+		s.AudioEngine.SetSwing(s.AudioEngine.GetSwing() + delta)
+	}
+}
+
+// GetSwing returns the current swing amount
+func (s *State) GetSwing() int {
+	if s.AudioEngine != nil {
+		return s.AudioEngine.GetSwing()
+	}
+	return 0
+}
+
 // GetBPM returns current BPM
 func (s *State) GetBPM() int {
 	if s.AudioEngine != nil {
@@ -291,6 +356,7 @@ func (s *State) NextPattern() {
 	if s.AudioEngine != nil {
 		s.AudioEngine.NextPattern()
 	}
+	s.clampEditCursor()
 }
 
 // PrevPattern cycles to previous beat pattern
@@ -298,6 +364,7 @@ func (s *State) PrevPattern() {
 	if s.AudioEngine != nil {
 		s.AudioEngine.PrevPattern()
 	}
+	s.clampEditCursor()
 }
 
 // GetPatternIndex returns current pattern index
@@ -308,7 +375,100 @@ func (s *State) GetPatternIndex() int {
 	return 0
 }
 
-// GetCurrentStep returns the current step (0-15)
+// CurrentPattern returns the playing pattern, including any edits
+func (s *State) CurrentPattern() audio.BeatPreset {
+	if s.AudioEngine != nil {
+		return s.AudioEngine.CurrentPattern()
+	}
+	return audio.DefaultPatterns()[0]
+}
+
+// LoadPatterns loads the pattern library from the built-in presets plus the
+// JSON files in dir, which is also where SavePattern writes. On error the
+// patterns that could be read are still used.
+func (s *State) LoadPatterns(dir string) error {
+	s.PatternDir = dir
+	patterns, err := audio.LoadPatterns(dir)
+	if s.AudioEngine != nil {
+		s.AudioEngine.SetPatterns(patterns)
+	}
+	s.clampEditCursor()
+	return err
+}
+
+// SavePattern writes the current pattern, including edits, to the pattern
+// directory and returns the file it wrote
+func (s *State) SavePattern() (string, error) {
+	if s.PatternDir == "" {
+		return "", fmt.Errorf("no pattern directory configured")
+	}
+	return audio.SavePattern(s.PatternDir, s.CurrentPattern())
+}
+
+// ToggleEditMode switches the step sequencer between playing and editing
+func (s *State) ToggleEditMode() {
+	s.EditMode = !s.EditMode
+}
+
+// MoveEditCursor moves the step cursor, wrapping around the grid
+func (s *State) MoveEditCursor(dRow, dStep int) {
+	steps := s.CurrentPattern().Steps()
+	rows := audio.NumRows + 1 // Drum rows plus the accent row
+	s.EditRow = (s.EditRow + dRow + rows) % rows
+	s.EditStep = (s.EditStep + dStep + steps) % steps
+}
+
+// clampEditCursor keeps the step cursor within a pattern just switched to,
+// which may be shorter
+func (s *State) clampEditCursor() {
+	s.EditStep = min(s.EditStep, s.CurrentPattern().Steps()-1)
+}
+
+// ToggleStep flips the hit, or the accent, under the edit cursor
+func (s *State) ToggleStep() {
+	if s.AudioEngine == nil {
+		return
+	}
+	if s.EditRow == audio.RowAccent {
+		s.AudioEngine.ToggleAccent(s.EditStep)
+		return
+	}
+
+	velocity := audio.DefaultVelocity
+	if s.CurrentPattern().Velocity(s.EditRow, s.EditStep) > 0 {
+		velocity = 0
+	}
+	s.AudioEngine.SetStep(s.EditRow, s.EditStep, velocity)
+}
+
+// ToggleAccent flips the accent on the step under the edit cursor
+func (s *State) ToggleAccent() {
+	if s.AudioEngine != nil {
+		s.AudioEngine.ToggleAccent(s.EditStep)
+	}
+}
+
+// AdjustStepVelocity changes the velocity of the hit under the edit cursor.
+// Rests are left alone so velocity keys can't add hits by accident.
+func (s *State) AdjustStepVelocity(delta int) {
+	if s.AudioEngine == nil || s.EditRow == audio.RowAccent {
+		return
+	}
+	velocity := s.CurrentPattern().Velocity(s.EditRow, s.EditStep)
+	if velocity == 0 {
+		return
+	}
+
+	velocity += delta
+	if velocity < audio.MinVelocity {
+		velocity = audio.MinVelocity
+	} else if velocity > audio.MaxVelocity {
+		velocity = audio.MaxVelocity
+	}
+	s.AudioEngine.SetStep(s.EditRow, s.EditStep, velocity)
+}
+
+// GetCurrentStep returns the playhead position within the current pattern
 func (s *State) GetCurrentStep() int {
 	if s.AudioEngine != nil {
 		return s.AudioEngine.GetCurrentStep()
@@ -316,12 +476,88 @@ func (s *State) GetCurrentStep() int {
 	return 0
 }
 
+// SetPattern selects a beat pattern by index
+func (s *State) SetPattern(index int) {
+	if s.AudioEngine != nil {
+		s.AudioEngine.SetPattern(index)
+	}
+	s.clampEditCursor()
+}
+
+// SetBPM sets the tempo
+func (s *State) SetBPM(bpm int) {
+	if s.AudioEngine != nil {
+		s.AudioEngine.SetBPM(bpm)
+	}
+}
+
+// SetSmoothing sets how quickly gain, pan, mute and solo changes ramp in
+func (s *State) SetSmoothing(d time.Duration) {
+	if s.AudioEngine != nil {
+		s.AudioEngine.SetSmoothing(d)
+	}
+}
+
+// Bounce renders the given number of bars of the current mix to a WAV file
+func (s *State) Bounce(path string, bars, bitDepth int) error {
+	if s.AudioEngine == nil {
+		return fmt.Errorf("audio engine not available")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.AudioEngine.RenderWAV(f, bars, bitDepth); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to render %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// TogglePlay starts or pauses the sequencer. Transport keys are ignored
+// while following an external clock, whose master owns the transport.
+func (s *State) TogglePlay() {
+	if s.AudioEngine == nil || s.AudioEngine.Following() {
+		return
+	}
+	if s.AudioEngine.Transport() == audio.TransportPlaying {
+		s.AudioEngine.Pause()
+	} else {
+		s.AudioEngine.Play()
+	}
+}
+
+// Stop halts the sequencer and rewinds it to the first step
+func (s *State) Stop() {
+	if s.AudioEngine != nil && !s.AudioEngine.Following() {
+		s.AudioEngine.Stop()
+	}
+}
+
+// Restart plays the pattern from the first step
+func (s *State) Restart() {
+	if s.AudioEngine != nil && !s.AudioEngine.Following() {
+		s.AudioEngine.Restart()
+	}
+}
+
+// Transport returns whether the sequencer is stopped, playing or paused
+func (s *State) Transport() audio.Transport {
+	if s.AudioEngine != nil {
+		return s.AudioEngine.Transport()
+	}
+	return audio.TransportStopped
+}
+
 // Close cleans up resources
 func (s *State) Close() {
+	close(s.done)
 	if s.AudioEngine != nil {
 		s.AudioEngine.Close()
 	}
 	if s.MidiHandler != nil {
 		s.MidiHandler.Close()
 	}
+	midi.CloseVirtualPorts()
 }
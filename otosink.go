@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+
+	"github.com/hajimehoshi/oto/v2"
+
+	"midi-mixer/audio"
+)
+
+// openSink creates the audio output named by -output: "oto" for the system
+// audio device, or one of the sinks audio.OpenSink knows
+func openSink(spec string) (audio.Sink, error) {
+	if spec == "" || spec == "oto" {
+		return &otoSink{}, nil
+	}
+	return audio.OpenSink(spec)
+}
+
+// otoSink plays through the system audio device
+type otoSink struct {
+	player oto.Player
+}
+
+// Start opens the audio device and starts playback
+func (s *otoSink) Start(r io.Reader) error {
+	ctx, ready, err := oto.NewContext(audio.SampleRate, audio.Channels, audio.BytesPerSample)
+	if err != nil {
+		return err
+	}
+	<-ready
+
+	s.player = ctx.NewPlayer(r)
+	s.player.Play()
+	return nil
+}
+
+// Close stops playback
+func (s *otoSink) Close() error {
+	if s.player != nil {
+		return s.player.Close()
+	}
+	return nil
+}
+
+func (s *otoSink) String() string {
+	return "oto"
+}
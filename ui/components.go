@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"midi-mixer/audio"
+	"midi-mixer/midi"
 	"midi-mixer/mixer"
 
 	"github.com/charmbracelet/lipgloss"
@@ -15,6 +16,7 @@ const (
 	FaderHeight    = 10 // Number of rows for fader display
 	WaveformWidth  = 80
 	WaveformHeight = 8
+	minGridWidth   = 44 // Narrowest beat grid, wide enough for its footer
 )
 
 // Friendly channel descriptions for non-musicians
@@ -29,10 +31,10 @@ var channelDescriptions = map[string]string{
 	"FX":    "🔮 Special effects & texture",
 }
 
-// RenderFader renders a vertical fader for a value 0-127
-func RenderFader(value uint8, height int) string {
+// RenderFader renders a vertical fader for a level 0-audio.MaxLevel
+func RenderFader(value uint16, height int) string {
 	// Calculate filled blocks
-	filled := int(float64(value) / 127.0 * float64(height))
+	filled := int(float64(value) / audio.MaxLevel * float64(height))
 
 	var lines []string
 	for i := height - 1; i >= 0; i-- {
@@ -47,22 +49,15 @@ func RenderFader(value uint8, height int) string {
 }
 
 // RenderPanKnob renders a simple pan indicator
-func RenderPanKnob(pan uint8) string {
-	// Convert 0-127 to position indicator
-	// 0 = full left, 64 = center, 127 = full right
+func RenderPanKnob(pan uint16) string {
+	// Convert the level to position indicator
+	// 0 = full left, LevelCenter = center, MaxLevel = full right
 	const width = 7
-	pos := int(float64(pan) / 127.0 * float64(width-1))
+	pos := int(float64(pan) / audio.MaxLevel * float64(width-1))
 
 	indicator := strings.Repeat("─", pos) + "●" + strings.Repeat("─", width-1-pos)
 
-	label := "C"
-	if pan < 54 {
-		label = fmt.Sprintf("L%d", (64-int(pan))*100/64)
-	} else if pan > 74 {
-		label = fmt.Sprintf("R%d", (int(pan)-64)*100/63)
-	}
-
-	return PanStyle.Render(fmt.Sprintf("[%s]\n %s", indicator, label))
+	return PanStyle.Render(fmt.Sprintf("[%s]\n %s", indicator, mixer.PanLabel(pan)))
 }
 
 // RenderChannel renders a single channel strip
@@ -81,7 +76,7 @@ func RenderChannel(ch mixer.Channel, selected bool) string {
 	parts = append(parts, RenderFader(ch.Volume, FaderHeight))
 
 	// Volume value with friendly indicator
-	volPercent := int(float64(ch.Volume) / 127.0 * 100)
+	volPercent := int(float64(ch.Volume) / audio.MaxLevel * 100)
 	volIndicator := ""
 	if volPercent == 0 {
 		volIndicator = "🔇"
@@ -122,14 +117,14 @@ func RenderChannel(ch mixer.Channel, selected bool) string {
 }
 
 // RenderMasterFader renders the master volume fader
-func RenderMasterFader(volume uint8) string {
+func RenderMasterFader(volume uint16) string {
 	var parts []string
 
 	parts = append(parts, ChannelNameStyle.Render("MASTER"))
 	parts = append(parts, "")
 	parts = append(parts, RenderFader(volume, FaderHeight))
 
-	volPercent := int(float64(volume) / 127.0 * 100)
+	volPercent := int(float64(volume) / audio.MaxLevel * 100)
 	parts = append(parts, ValueStyle.Render(fmt.Sprintf("%3d%%", volPercent)))
 
 	return MasterStyle.Render(strings.Join(parts, "\n"))
@@ -153,116 +148,293 @@ func RenderMixer(state *mixer.State) string {
 
 // RenderHelp renders the help bar
 func RenderHelp() string {
-	help := "←/→: Select  ↑/↓: Volume  [/]: Pan  M: Mute  S: Solo  P: Pattern  +/-: BPM  D: Devices  Q: Quit"
+	help := "←/→: Select  ↑/↓: Volume  [/]: Pan  M: Mute  S: Solo  Space: Play  X: Stop  R: Restart  P: Pattern  E: Edit  +/-: BPM  g/G: Swing  C/F: Clock Out/In  L: Learn  D: Devices  I: Monitor  Q: Quit"
 	return HelpStyle.Render(help)
 }
 
 // RenderStatus renders the status bar with MIDI info
 func RenderStatus(state *mixer.State) string {
-	inPort := state.MidiHandler.GetInputPortName()
-	outPort := state.MidiHandler.GetOutputPortName()
+	var ins, outs []string
+	for _, d := range state.Devices() {
+		if d.In != nil {
+			ins = append(ins, d.In.String())
+		}
+		if d.Out != nil {
+			outs = append(outs, d.Out.String())
+		}
+	}
+	inPort, outPort := "None", "None"
+	if len(ins) > 0 {
+		inPort = strings.Join(ins, ", ")
+	}
+	if len(outs) > 0 {
+		outPort = strings.Join(outs, ", ")
+	}
 
-	status := fmt.Sprintf("MIDI In: %s │ MIDI Out: %s", inPort, outPort)
+	audioOut := "None"
+	if state.AudioEngine != nil {
+		audioOut = state.AudioEngine.OutputName()
+	}
+
+	status := fmt.Sprintf("Audio: %s │ MIDI In: %s │ MIDI Out: %s", audioOut, inPort, outPort)
+	if state.Following() {
+		if state.AudioEngine.ExternalPlaying() {
+			status += " │ Clock In"
+		} else {
+			status += " │ Clock In (stopped)"
+		}
+	}
+	if state.ClockOutput() {
+		status += " │ Clock Out"
+	}
+	if bank, ok := state.MCUBank(); ok {
+		last := min(bank+midi.MCUStrips, len(state.Channels))
+		status += fmt.Sprintf(" │ MCU %d-%d", bank+1, last)
+	}
+	if n := state.Dropped(); n > 0 {
+		status += fmt.Sprintf(" │ ⚠ Dropped %d MIDI", n)
+	}
+	if lost := state.LostDevices(); len(lost) > 0 {
+		names := make([]string, len(lost))
+		for i, d := range lost {
+			names[i] = d.Name()
+		}
+		status += " │ ⚠ Lost: " + strings.Join(names, ", ")
+	}
 	return StatusStyle.Render(status)
 }
 
-// RenderPatternInfo renders the current pattern name and description
-func RenderPatternInfo(patternIdx int) string {
-	if patternIdx >= len(audio.BeatPresets) {
-		patternIdx = 0
+// RenderLearnBar renders the MIDI learn prompt for a target and its current
+// binding, if any
+func RenderLearnBar(target, binding string) string {
+	learnStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#F59E0B"))
+
+	hintStyle := lipgloss.NewStyle().
+		Foreground(ColorTextDim)
+
+	if binding == "" {
+		binding = "unbound"
+	}
+	prompt := fmt.Sprintf("🎓 LEARN %s (%s) ─ move a control to bind it", target, binding)
+	return learnStyle.Render(prompt) + "  " + hintStyle.Render("←/→: Channel  ↑/↓: Target  Del: Unbind  Esc: Done")
+}
+
+// TransportLabel renders the transport state for the title bar
+func TransportLabel(t audio.Transport) string {
+	switch t {
+	case audio.TransportPlaying:
+		return "▶ PLAYING"
+	case audio.TransportPaused:
+		return "⏸ PAUSED"
 	}
-	pattern := audio.BeatPresets[patternIdx]
+	return "■ STOPPED"
+}
 
+// RenderPatternInfo renders the current pattern name, meter and description
+func RenderPatternInfo(pattern audio.BeatPreset) string {
 	nameStyle := lipgloss.NewStyle().
 		Bold(true).
 		Foreground(lipgloss.Color("#F59E0B"))
 
+	meterStyle := lipgloss.NewStyle().
+		Foreground(ColorTextDim)
+
 	descStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#9CA3AF")).
 		Italic(true)
 
-	return nameStyle.Render(pattern.Name) + "  " + descStyle.Render(pattern.Description)
+	num, den := pattern.TimeSignature()
+	meter := fmt.Sprintf("%d/%d · %s", num, den, resolutionName(pattern.StepsPerBeat()))
+
+	return nameStyle.Render(pattern.Name) + "  " + meterStyle.Render(meter) + "  " + descStyle.Render(pattern.Description)
 }
 
-// RenderStepSequencer renders a visual step sequencer showing the current beat
-func RenderStepSequencer(patternIdx int, currentStep int) string {
-	if patternIdx >= len(audio.BeatPresets) {
-		patternIdx = 0
+// resolutionName describes a step length in note values
+func resolutionName(stepsPerBeat int) string {
+	switch stepsPerBeat {
+	case 1:
+		return "quarters"
+	case 2:
+		return "8ths"
+	case 3:
+		return "8th triplets"
+	case 4:
+		return "16ths"
+	case 6:
+		return "16th triplets"
+	case 8:
+		return "32nds"
+	}
+	return fmt.Sprintf("%d steps/beat", stepsPerBeat)
+}
+
+// RenderStepSequencer renders a visual step sequencer showing the current
+// beat. When editing, editRow and editStep give the cursor position;
+// pass -1 for editRow otherwise.
+func RenderStepSequencer(pattern audio.BeatPreset, currentStep int, editRow, editStep int) string {
+	steps := pattern.Steps()
+	stepsPerBeat := pattern.StepsPerBeat()
+	stepsPerBar := pattern.StepsPerBar()
+
+	// Short patterns get a spaced grid with hex step numbers; long ones are
+	// packed one column per step and numbered by beat
+	cellWidth := 2
+	if steps > 24 {
+		cellWidth = 1
+	}
+	gap := strings.Repeat(" ", cellWidth-1)
+
+	// Keep the box wide enough for its titles, padding short grids
+	innerWidth := 2 + steps*cellWidth
+	padding := ""
+	if innerWidth < minGridWidth {
+		padding = strings.Repeat(" ", minGridWidth-innerWidth)
+		innerWidth = minGridWidth
+	}
+
+	boxLine := func(left, title, right string) string {
+		fill := innerWidth - lipgloss.Width(title)
+		if fill < 0 {
+			fill = 0
+		}
+		return left + title + strings.Repeat("─", fill) + right
 	}
-	pattern := audio.BeatPresets[patternIdx]
 
 	var lines []string
 
 	headerStyle := lipgloss.NewStyle().Foreground(ColorAccent).Bold(true)
-	lines = append(lines, headerStyle.Render("┌─ BEAT GRID ────────────────────────────────────┐"))
+	if editRow >= 0 {
+		title := "─ BEAT GRID ─ EDITING "
+		if editRow < audio.NumRows {
+			if vel := pattern.Velocity(editRow, editStep); vel > 0 {
+				title += fmt.Sprintf("─ VEL %d ", vel)
+			}
+		}
+		lines = append(lines, headerStyle.Render(boxLine("┌", title, "┐")))
+	} else {
+		lines = append(lines, headerStyle.Render(boxLine("┌", "─ BEAT GRID ", "┐")))
+	}
 
 	// Step numbers
-	stepNums := "│ "
-	for i := 0; i < 16; i++ {
+	stepNums := "│  "
+	for i := 0; i < steps; i++ {
+		label := "·"
+		if steps <= 16 {
+			label = fmt.Sprintf("%X", i)
+		} else if i%stepsPerBeat == 0 {
+			label = fmt.Sprintf("%d", (i%stepsPerBar)/stepsPerBeat+1)
+		}
+
 		if i == currentStep {
-			stepNums += lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#22C55E")).Render(fmt.Sprintf("%X", i))
-		} else if i%4 == 0 {
-			stepNums += lipgloss.NewStyle().Foreground(ColorAccent).Render(fmt.Sprintf("%X", i))
+			stepNums += lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#22C55E")).Render(label)
+		} else if i%stepsPerBeat == 0 {
+			stepNums += lipgloss.NewStyle().Foreground(ColorAccent).Render(label)
 		} else {
-			stepNums += lipgloss.NewStyle().Foreground(ColorTextDim).Render(fmt.Sprintf("%X", i))
+			stepNums += lipgloss.NewStyle().Foreground(ColorTextDim).Render(label)
 		}
-		stepNums += " "
+		stepNums += gap
 	}
-	stepNums += "│"
+	stepNums += padding + "│"
 	lines = append(lines, stepNums)
 
 	// Separator
-	lines = append(lines, lipgloss.NewStyle().Foreground(ColorSurface).Render("├─────────────────────────────────────────────────┤"))
+	lines = append(lines, lipgloss.NewStyle().Foreground(ColorSurface).Render(boxLine("├", "", "┤")))
 
 	// Patterns for each drum
-	drumPatterns := []struct {
-		name    string
-		pattern []int
-		color   lipgloss.Color
-		emoji   string
+	drumRows := []struct {
+		row   int
+		color lipgloss.Color
+		emoji string
 	}{
-		{"KICK ", pattern.Kick, lipgloss.Color("#EF4444"), "💥"},
-		{"SNARE", pattern.Snare, lipgloss.Color("#F59E0B"), "🥁"},
-		{"HIHAT", pattern.HiHat, lipgloss.Color("#22C55E"), "✨"},
-		{"BASS ", pattern.Bass, lipgloss.Color("#3B82F6"), "🎸"},
+		{audio.RowKick, lipgloss.Color("#EF4444"), "💥"},
+		{audio.RowSnare, lipgloss.Color("#F59E0B"), "🥁"},
+		{audio.RowHiHat, lipgloss.Color("#22C55E"), "✨"},
+		{audio.RowBass, lipgloss.Color("#3B82F6"), "🎸"},
 	}
 
-	for _, dp := range drumPatterns {
-		line := "│" + dp.emoji
-		activeStyle := lipgloss.NewStyle().Foreground(dp.color).Bold(true)
-		inactiveStyle := lipgloss.NewStyle().Foreground(ColorSurface)
-		playheadStyle := lipgloss.NewStyle().Background(lipgloss.Color("#4ADE80")).Foreground(lipgloss.Color("#000000")).Bold(true)
+	activeStyle := lipgloss.NewStyle().Bold(true)
+	inactiveStyle := lipgloss.NewStyle().Foreground(ColorSurface)
+	playheadStyle := lipgloss.NewStyle().Background(lipgloss.Color("#4ADE80")).Foreground(lipgloss.Color("#000000")).Bold(true)
+	cursorStyle := lipgloss.NewStyle().Background(ColorPrimary).Foreground(ColorText).Bold(true)
 
-		for i, hit := range dp.pattern {
+	for _, dr := range drumRows {
+		line := "│" + dr.emoji
+		rowStyle := activeStyle.Foreground(dr.color)
+
+		for i := 0; i < steps; i++ {
+			vel := pattern.Velocity(dr.row, i)
 			char := "·"
-			if hit == 1 {
-				char = "█"
+			if vel > 0 {
+				char = velocityGlyph(vel)
 			}
 
-			if i == currentStep {
-				if hit == 1 {
+			if dr.row == editRow && i == editStep {
+				line += cursorStyle.Render(char)
+			} else if i == currentStep {
+				if vel > 0 {
 					line += playheadStyle.Render(char)
 				} else {
 					line += playheadStyle.Render("▪")
 				}
-			} else if hit == 1 {
-				line += activeStyle.Render(char)
+			} else if vel > 0 {
+				line += rowStyle.Render(char)
+			} else {
+				line += inactiveStyle.Render(char)
+			}
+			line += gap
+		}
+		line += padding + "│"
+		lines = append(lines, line)
+	}
+
+	// Accent row, shown when the pattern uses accents or while editing
+	if len(pattern.Accent) > 0 || editRow >= 0 {
+		line := "│⚡"
+		accentStyle := activeStyle.Foreground(ColorPrimary)
+		for i := 0; i < steps; i++ {
+			char := "·"
+			if pattern.Accented(i) {
+				char = ">"
+			}
+
+			if editRow == audio.RowAccent && i == editStep {
+				line += cursorStyle.Render(char)
+			} else if pattern.Accented(i) {
+				line += accentStyle.Render(char)
 			} else {
 				line += inactiveStyle.Render(char)
 			}
-			line += " "
+			line += gap
 		}
-		line += "│"
+		line += padding + "│"
 		lines = append(lines, line)
 	}
 
 	// Footer
 	footerStyle := lipgloss.NewStyle().Foreground(ColorTextDim)
-	lines = append(lines, footerStyle.Render("└─ Press P to change pattern, +/- for tempo ─────┘"))
+	if editRow >= 0 {
+		lines = append(lines, footerStyle.Render(boxLine("└", "─ Space: hit  [/]: vel  A: accent  E: done ", "┘")))
+	} else {
+		lines = append(lines, footerStyle.Render(boxLine("└", "─ P: pattern  +/-: tempo  E: edit ", "┘")))
+	}
 
 	return strings.Join(lines, "\n")
 }
 
+// velocityBlocks draw hits from ghost notes up to full strength
+var velocityBlocks = []string{"▂", "▃", "▄", "▅", "▆", "▇", "█"}
+
+// velocityGlyph picks a block for a hit, full height from DefaultVelocity up
+func velocityGlyph(velocity int) string {
+	idx := velocity * len(velocityBlocks) / (audio.DefaultVelocity + 1)
+	if idx >= len(velocityBlocks) {
+		idx = len(velocityBlocks) - 1
+	}
+	return velocityBlocks[idx]
+}
+
 // RenderChannelDescription renders a description for the selected channel
 func RenderChannelDescription(channelName string) string {
 	desc, ok := channelDescriptions[channelName]
@@ -9,65 +9,140 @@ import (
 	"midi-mixer/midi"
 )
 
+// DeviceList identifies one of the lists on the device screen
+type DeviceList int
+
+const (
+	ListInput DeviceList = iota
+	ListOutput
+	ListProfile
+	ListConnected
+	numDeviceLists
+)
+
+// ConnectedDevice is a connected MIDI device and the name of its mapping.
+// Lost devices are waiting for their ports to come back.
+type ConnectedDevice struct {
+	midi.Device
+	Mapping string
+	Lost    bool
+}
+
 // DeviceSelector handles device selection UI
 type DeviceSelector struct {
-	InputPorts     []drivers.In
-	OutputPorts    []drivers.Out
-	SelectedInput  int
-	SelectedOutput int
-	FocusInput     bool // true = input list focused, false = output list
+	InputPorts        []drivers.In
+	OutputPorts       []drivers.Out
+	Profiles          []string // Mapping names
+	Connected         []ConnectedDevice
+	SelectedInput     int
+	SelectedOutput    int
+	SelectedProfile   int
+	SelectedConnected int
+	Channel           int // MIDI channel filter for the next connection
+	Focus             DeviceList
 }
 
-// NewDeviceSelector creates a new device selector
-func NewDeviceSelector() *DeviceSelector {
+// NewDeviceSelector creates a new device selector offering the given
+// mappings, with the default one preselected (-1 for none), and listing the
+// devices already connected
+func NewDeviceSelector(mappings []string, defaultMapping int, connected []ConnectedDevice) *DeviceSelector {
 	return &DeviceSelector{
-		InputPorts:     midi.GetInputPorts(),
-		OutputPorts:    midi.GetOutputPorts(),
-		SelectedInput:  -1,
-		SelectedOutput: -1,
-		FocusInput:     true,
+		InputPorts:        midi.GetInputPorts(),
+		OutputPorts:       midi.GetOutputPorts(),
+		Profiles:          mappings,
+		Connected:         connected,
+		SelectedInput:     -1,
+		SelectedOutput:    -1,
+		SelectedProfile:   defaultMapping,
+		SelectedConnected: -1,
+		Channel:           midi.AllChannels,
+		Focus:             ListInput,
 	}
 }
 
 // Refresh reloads available MIDI ports
 func (d *DeviceSelector) Refresh() {
-	d.InputPorts = midi.GetInputPorts()
-	d.OutputPorts = midi.GetOutputPorts()
+	d.SetPorts(midi.GetInputPorts(), midi.GetOutputPorts())
+}
+
+// SetPorts updates the available MIDI ports, keeping the selected ports
+// selected where they're still available
+func (d *DeviceSelector) SetPorts(ins []drivers.In, outs []drivers.Out) {
+	in, out := d.GetSelectedInput(), d.GetSelectedOutput()
+	d.InputPorts, d.OutputPorts = ins, outs
+	d.SelectedInput = findPort(ins, in)
+	d.SelectedOutput = findPort(outs, out)
+}
+
+// findPort returns the index of the port named like port, or -1
+func findPort[P drivers.Port](ports []P, port drivers.Port) int {
+	if port == nil {
+		return -1
+	}
+	for i, p := range ports {
+		if p.String() == port.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+// focused returns the selection and length of the focused list
+func (d *DeviceSelector) focused() (*int, int) {
+	switch d.Focus {
+	case ListOutput:
+		return &d.SelectedOutput, len(d.OutputPorts)
+	case ListProfile:
+		return &d.SelectedProfile, len(d.Profiles)
+	case ListConnected:
+		return &d.SelectedConnected, len(d.Connected)
+	}
+	return &d.SelectedInput, len(d.InputPorts)
 }
 
 // MoveUp moves selection up in current list
 func (d *DeviceSelector) MoveUp() {
-	if d.FocusInput {
-		if d.SelectedInput > 0 {
-			d.SelectedInput--
-		} else if d.SelectedInput == -1 && len(d.InputPorts) > 0 {
-			d.SelectedInput = 0
-		}
-	} else {
-		if d.SelectedOutput > 0 {
-			d.SelectedOutput--
-		} else if d.SelectedOutput == -1 && len(d.OutputPorts) > 0 {
-			d.SelectedOutput = 0
-		}
+	selected, n := d.focused()
+	if *selected > 0 {
+		*selected--
+	} else if *selected == -1 && n > 0 {
+		*selected = 0
 	}
 }
 
 // MoveDown moves selection down in current list
 func (d *DeviceSelector) MoveDown() {
-	if d.FocusInput {
-		if d.SelectedInput < len(d.InputPorts)-1 {
-			d.SelectedInput++
-		}
-	} else {
-		if d.SelectedOutput < len(d.OutputPorts)-1 {
-			d.SelectedOutput++
-		}
+	selected, n := d.focused()
+	if *selected < n-1 {
+		*selected++
 	}
 }
 
-// ToggleFocus switches between input and output lists
+// ToggleFocus moves to the next list: inputs, outputs, mappings, then
+// connected devices
 func (d *DeviceSelector) ToggleFocus() {
-	d.FocusInput = !d.FocusInput
+	d.Focus = (d.Focus + 1) % numDeviceLists
+}
+
+// AdjustChannel steps the channel filter through all channels and 1-16
+func (d *DeviceSelector) AdjustChannel(delta int) {
+	// AllChannels is -1, so there are 17 choices starting from it
+	d.Channel = (d.Channel+1+delta+17)%17 - 1
+}
+
+// GetSelectedConnected returns the selected connected device, if any
+func (d *DeviceSelector) GetSelectedConnected() (midi.Device, bool) {
+	if d.SelectedConnected >= 0 && d.SelectedConnected < len(d.Connected) {
+		return d.Connected[d.SelectedConnected].Device, true
+	}
+	return midi.Device{}, false
+}
+
+// SetConnected updates the list of connected devices, keeping the selection
+// within it
+func (d *DeviceSelector) SetConnected(connected []ConnectedDevice) {
+	d.Connected = connected
+	d.SelectedConnected = min(d.SelectedConnected, len(connected)-1)
 }
 
 // GetSelectedInput returns the selected input port or nil
@@ -94,59 +169,93 @@ func RenderDeviceSelector(d *DeviceSelector) string {
 	sections = append(sections, TitleStyle.Render("🎹 MIDI Device Selection"))
 	sections = append(sections, "")
 
-	// Input ports list
-	inputTitle := "Input Ports"
-	if d.FocusInput {
-		inputTitle = "▸ Input Ports"
-	}
-	sections = append(sections, ChannelNameStyle.Render(inputTitle))
-
-	if len(d.InputPorts) == 0 {
-		sections = append(sections, DeviceItemStyle.Render("  No input devices found"))
-	} else {
-		for i, port := range d.InputPorts {
-			name := port.String()
-			if i == d.SelectedInput {
-				if d.FocusInput {
-					sections = append(sections, DeviceSelectedStyle.Render(fmt.Sprintf("● %s", name)))
-				} else {
-					sections = append(sections, DeviceItemStyle.Render(fmt.Sprintf("● %s", name)))
-				}
-			} else {
-				sections = append(sections, DeviceItemStyle.Render(fmt.Sprintf("  %s", name)))
-			}
-		}
+	inputs := make([]string, len(d.InputPorts))
+	for i, port := range d.InputPorts {
+		inputs[i] = portName(port)
 	}
+	sections = append(sections, renderDeviceList("Input Ports", "No input devices found", inputs, d.SelectedInput, d.Focus == ListInput)...)
+	sections = append(sections, "")
 
+	outputs := make([]string, len(d.OutputPorts))
+	for i, port := range d.OutputPorts {
+		outputs[i] = portName(port)
+	}
+	sections = append(sections, renderDeviceList("Output Ports", "No output devices found", outputs, d.SelectedOutput, d.Focus == ListOutput)...)
 	sections = append(sections, "")
 
-	// Output ports list
-	outputTitle := "Output Ports"
-	if !d.FocusInput {
-		outputTitle = "▸ Output Ports"
-	}
-	sections = append(sections, ChannelNameStyle.Render(outputTitle))
-
-	if len(d.OutputPorts) == 0 {
-		sections = append(sections, DeviceItemStyle.Render("  No output devices found"))
-	} else {
-		for i, port := range d.OutputPorts {
-			name := port.String()
-			if i == d.SelectedOutput {
-				if !d.FocusInput {
-					sections = append(sections, DeviceSelectedStyle.Render(fmt.Sprintf("● %s", name)))
-				} else {
-					sections = append(sections, DeviceItemStyle.Render(fmt.Sprintf("● %s", name)))
-				}
-			} else {
-				sections = append(sections, DeviceItemStyle.Render(fmt.Sprintf("  %s", name)))
-			}
-		}
+	sections = append(sections, renderDeviceList("Mapping", "No profiles found", d.Profiles, d.SelectedProfile, d.Focus == ListProfile)...)
+	sections = append(sections, "")
+
+	sections = append(sections, ChannelNameStyle.Render("MIDI Channel  ◀ "+channelFilterName(d.Channel)+" ▶"))
+	sections = append(sections, "")
+
+	connected := make([]string, len(d.Connected))
+	for i, c := range d.Connected {
+		connected[i] = connectedName(c)
 	}
+	sections = append(sections, renderDeviceList("Connected", "No devices connected", connected, d.SelectedConnected, d.Focus == ListConnected)...)
 
 	sections = append(sections, "")
-	sections = append(sections, HelpStyle.Render("↑/↓: Select  Tab: Switch List  Enter: Connect  R: Refresh  Esc: Cancel"))
+	sections = append(sections, HelpStyle.Render("↑/↓: Select  Tab: Switch List  ←/→: Channel  Enter: Connect  Del: Disconnect  R: Refresh  Esc: Cancel"))
 
 	content := strings.Join(sections, "\n")
 	return DeviceListStyle.Render(content)
 }
+
+// channelFilterName describes a channel filter, counting channels from 1
+func channelFilterName(channel int) string {
+	if channel == midi.AllChannels {
+		return "All"
+	}
+	return fmt.Sprint(channel + 1)
+}
+
+// connectedName describes a connected device: its ports, mapping and
+// channel filter, and whether it was lost
+func connectedName(c ConnectedDevice) string {
+	in, out := "None", "None"
+	if c.In != nil {
+		in = portName(c.In)
+	}
+	if c.Out != nil {
+		out = portName(c.Out)
+	}
+	name := fmt.Sprintf("%s → %s · %s · Channel %s", in, out, c.Mapping, channelFilterName(c.Channel))
+	if c.Lost {
+		name += " · Lost, waiting to reconnect"
+	}
+	return name
+}
+
+// portName labels a port for the device lists, marking the mixer's own
+// virtual ports
+func portName(port drivers.Port) string {
+	if midi.IsVirtual(port) {
+		return port.String() + " (virtual)"
+	}
+	return port.String()
+}
+
+// renderDeviceList renders one titled list of the device screen
+func renderDeviceList(title, empty string, items []string, selected int, focus bool) []string {
+	if focus {
+		title = "▸ " + title
+	}
+	lines := []string{ChannelNameStyle.Render(title)}
+
+	if len(items) == 0 {
+		return append(lines, DeviceItemStyle.Render("  "+empty))
+	}
+	for i, name := range items {
+		if i == selected {
+			if focus {
+				lines = append(lines, DeviceSelectedStyle.Render(fmt.Sprintf("● %s", name)))
+			} else {
+				lines = append(lines, DeviceItemStyle.Render(fmt.Sprintf("● %s", name)))
+			}
+		} else {
+			lines = append(lines, DeviceItemStyle.Render(fmt.Sprintf("  %s", name)))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,249 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	gomidi "gitlab.com/gomidi/midi/v2"
+
+	"midi-mixer/midi"
+)
+
+// MonitorKinds are the message types the monitor can be filtered to
+var MonitorKinds = []string{"All", "Control Change", "Note", "Pitch Bend", "Program Change", "SysEx", "Transport", "Other"}
+
+// MonitorDirection filters the monitor to received or sent messages
+type MonitorDirection int
+
+const (
+	MonitorBoth MonitorDirection = iota
+	MonitorIn
+	MonitorOut
+)
+
+// String names the direction for the filter bar
+func (d MonitorDirection) String() string {
+	switch d {
+	case MonitorIn:
+		return "In"
+	case MonitorOut:
+		return "Out"
+	}
+	return "In+Out"
+}
+
+// Monitor holds the MIDI monitor's filters and scroll position
+type Monitor struct {
+	Kind      int // Index into MonitorKinds
+	Channel   int // MIDI channel shown, 0-15, or midi.AllChannels
+	Direction MonitorDirection
+	ShowClock bool // Show timing clock and active sensing, which flood the log
+	Scroll    int  // Messages scrolled back from the newest
+	paused    []midi.MonitorEntry
+}
+
+// NewMonitor creates a monitor showing all messages but clock
+func NewMonitor() *Monitor {
+	return &Monitor{Channel: midi.AllChannels}
+}
+
+// CycleKind steps the message type filter
+func (m *Monitor) CycleKind(delta int) {
+	m.Kind = (m.Kind + delta + len(MonitorKinds)) % len(MonitorKinds)
+	m.Scroll = 0
+}
+
+// AdjustChannel steps the channel filter through all channels and 1-16
+func (m *Monitor) AdjustChannel(delta int) {
+	// AllChannels is -1, so there are 17 choices starting from it
+	m.Channel = (m.Channel+1+delta+17)%17 - 1
+	m.Scroll = 0
+}
+
+// CycleDirection steps between received and sent messages, and both
+func (m *Monitor) CycleDirection() {
+	m.Direction = (m.Direction + 1) % 3
+	m.Scroll = 0
+}
+
+// ToggleClock shows or hides timing clock and active sensing
+func (m *Monitor) ToggleClock() {
+	m.ShowClock = !m.ShowClock
+	m.Scroll = 0
+}
+
+// ScrollBy scrolls back (positive) or forward through the total messages
+// shown
+func (m *Monitor) ScrollBy(delta, total int) {
+	m.Scroll = max(0, min(m.Scroll+delta, total-1))
+}
+
+// TogglePause freezes the log at entries, or goes back to following it
+func (m *Monitor) TogglePause(entries []midi.MonitorEntry) {
+	if m.paused != nil {
+		m.paused = nil
+	} else {
+		m.paused = append([]midi.MonitorEntry{}, entries...)
+	}
+	m.Scroll = 0
+}
+
+// Paused reports whether the log is frozen
+func (m *Monitor) Paused() bool {
+	return m.paused != nil
+}
+
+// Filter returns the messages the filters let through, oldest first, from
+// entries or from the frozen log while paused
+func (m *Monitor) Filter(entries []midi.MonitorEntry) []midi.MonitorEntry {
+	if m.paused != nil {
+		entries = m.paused
+	}
+	var shown []midi.MonitorEntry
+	for _, e := range entries {
+		if m.shows(e) {
+			shown = append(shown, e)
+		}
+	}
+	return shown
+}
+
+// shows reports whether a message passes the filters
+func (m *Monitor) shows(e midi.MonitorEntry) bool {
+	switch {
+	case m.Direction == MonitorIn && e.Out, m.Direction == MonitorOut && !e.Out:
+		return false
+	case !m.ShowClock && e.Message.IsOneOf(gomidi.TimingClockMsg, gomidi.ActiveSenseMsg):
+		return false
+	case m.Kind != 0 && MonitorKinds[m.Kind] != messageKind(e.Message):
+		return false
+	}
+	var ch uint8
+	return m.Channel == midi.AllChannels || (e.Message.GetChannel(&ch) && int(ch) == m.Channel)
+}
+
+// messageKind returns the MonitorKinds entry a message belongs to
+func messageKind(msg gomidi.Message) string {
+	switch {
+	case msg.Is(gomidi.ControlChangeMsg):
+		return "Control Change"
+	case msg.IsOneOf(gomidi.NoteOnMsg, gomidi.NoteOffMsg, gomidi.PolyAfterTouchMsg):
+		return "Note"
+	case msg.Is(gomidi.PitchBendMsg):
+		return "Pitch Bend"
+	case msg.Is(gomidi.ProgramChangeMsg):
+		return "Program Change"
+	case msg.Is(gomidi.SysExMsg):
+		return "SysEx"
+	case msg.IsOneOf(gomidi.StartMsg, gomidi.StopMsg, gomidi.ContinueMsg, gomidi.SPPMsg):
+		return "Transport"
+	}
+	return "Other"
+}
+
+// messageData describes a message's data bytes, or lists them in hex
+func messageData(msg gomidi.Message) string {
+	var ch, a, b uint8
+	var rel int16
+	var abs, spp uint16
+	switch {
+	case msg.GetControlChange(&ch, &a, &b):
+		return fmt.Sprintf("cc %d = %d", a, b)
+	case msg.GetNoteOn(&ch, &a, &b), msg.GetNoteOff(&ch, &a, &b):
+		return fmt.Sprintf("key %d vel %d", a, b)
+	case msg.GetPolyAfterTouch(&ch, &a, &b):
+		return fmt.Sprintf("key %d pressure %d", a, b)
+	case msg.GetAfterTouch(&ch, &a):
+		return fmt.Sprintf("pressure %d", a)
+	case msg.GetProgramChange(&ch, &a):
+		return fmt.Sprintf("program %d", a)
+	case msg.GetPitchBend(&ch, &rel, &abs):
+		return fmt.Sprintf("bend %+d", rel)
+	case msg.GetSPP(&spp):
+		return fmt.Sprintf("position %d", spp)
+	}
+
+	const maxBytes = 12
+	data := msg.Bytes()
+	hex := fmt.Sprintf("% X", data[:min(len(data), maxBytes)])
+	if len(data) > maxBytes {
+		hex += " …"
+	}
+	return hex
+}
+
+// monitorLine formats one logged message
+func monitorLine(e midi.MonitorEntry) string {
+	dir, stamp := "In", fmt.Sprint(e.Timestamp)
+	if e.Out {
+		dir, stamp = "Out", ""
+	}
+	ch := ""
+	var c uint8
+	if e.Message.GetChannel(&c) {
+		ch = fmt.Sprint(c + 1)
+	}
+	port := []rune(e.Port)
+	if len(port) > 20 {
+		port = append(port[:19], '…')
+	}
+	return fmt.Sprintf("%s %9s  %-3s  %-20s  %-16s %2s  %s",
+		e.Time.Format("15:04:05.000"), stamp, dir, string(port), messageType(e.Message), ch, messageData(e.Message))
+}
+
+// messageType names a message's type
+func messageType(msg gomidi.Message) string {
+	if msg.Is(gomidi.SysExMsg) {
+		return "SysEx" // Rather than gomidi's "SysExType"
+	}
+	return msg.Type().String()
+}
+
+// RenderMonitor renders the MIDI monitor view, showing the latest lines of
+// entries that pass its filters
+func RenderMonitor(m *Monitor, entries []midi.MonitorEntry, lines int) string {
+	var sections []string
+
+	sections = append(sections, TitleStyle.Render("🔍 MIDI Monitor"))
+	sections = append(sections, "")
+
+	state := "● Live"
+	if m.Paused() {
+		state = "⏸ Paused"
+	}
+	clock := "hidden"
+	if m.ShowClock {
+		clock = "shown"
+	}
+	filters := fmt.Sprintf("Type ◀ %s ▶  Channel ◀ %s ▶  Direction: %s  Clock: %s  %s",
+		MonitorKinds[m.Kind], channelFilterName(m.Channel), m.Direction, clock, state)
+	sections = append(sections, MonitorHeaderStyle.Render(filters))
+	sections = append(sections, "")
+
+	header := fmt.Sprintf("%-12s %9s  %-3s  %-20s  %-16s %2s  %s", "Time", "Stamp ms", "Dir", "Port", "Type", "Ch", "Data")
+	sections = append(sections, MonitorHeaderStyle.Render(header))
+
+	shown := m.Filter(entries)
+	end := len(shown) - min(m.Scroll, len(shown))
+	rows := make([]string, 0, lines)
+	for _, e := range shown[max(0, end-lines):end] {
+		if e.Out {
+			rows = append(rows, MonitorOutStyle.Render(monitorLine(e)))
+		} else {
+			rows = append(rows, DeviceItemStyle.Render(monitorLine(e)))
+		}
+	}
+	if len(shown) == 0 {
+		rows = append(rows, DeviceItemStyle.Render("No MIDI messages yet"))
+	}
+	// Keep the view's height steady as messages come in
+	for len(rows) < lines {
+		rows = append(rows, "")
+	}
+	sections = append(sections, rows...)
+
+	sections = append(sections, "")
+	sections = append(sections, HelpStyle.Render("Tab: Type  ←/→: Channel  I: In/Out  C: Clock  Space: Pause  ↑/↓: Scroll  X: Clear  Esc: Back"))
+
+	return MonitorStyle.Render(strings.Join(sections, "\n"))
+}
@@ -119,6 +119,21 @@ var (
 				Background(ColorPrimary).
 				Padding(0, 2)
 
+	// MIDI monitor, as wide as its lines
+	MonitorStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(ColorSurface).
+			Padding(1)
+
+	MonitorHeaderStyle = lipgloss.NewStyle().
+				Bold(true).
+				Foreground(ColorText).
+				Padding(0, 2)
+
+	MonitorOutStyle = lipgloss.NewStyle().
+			Foreground(ColorTextDim).
+			Padding(0, 2)
+
 	// Master fader
 	MasterStyle = lipgloss.NewStyle().
 			Border(lipgloss.DoubleBorder()).